@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"jo-m.ch/go/gobef93/pkg/bef93"
+)
+
+// stringList is a flag which can be given multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func runCover(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gobef93 cover", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	opts := bef93.Opts{}
+	registerOptsFlags(fs, &opts)
+
+	inputs := stringList{}
+	fs.Var(&inputs, "input", "File to read program input from. Can be given multiple times, the program is executed once per file. If not given, the program is executed once, reading from stdin.")
+	merge := stringList{}
+	fs.Var(&merge, "merge", "Coverage profile to merge into the result. Can be given multiple times.")
+	noRun := fs.Bool("no_run", false, "Do not execute the program, only merge coverage profiles.")
+	profile := fs.String("profile", "", "File to write the merged coverage profile to.")
+	minPercent := fs.Float64("min", 0, "Minimum coverage in percent. If the coverage is lower, exit with code 5.")
+	color := fs.Bool("color", false, "Mark uncovered cells using ANSI colors instead of a marker line.")
+
+	fs.Usage = func() {
+		w := fs.Output()
+
+		fmt.Fprintf(w, "Usage of %s:\n", fs.Name())
+		fmt.Fprintf(w, `Executes a Befunge-93 program file and reports which cells were executed.
+Takes a single positional argument, which is the file to execute.
+Cells which are not spaces in the program are coverable.
+Program output is written to stderr, the report to stdout.
+`)
+
+		fs.PrintDefaults()
+	}
+
+	srcFile, code, ok := parseFileArg(fs, args)
+	if !ok {
+		return code
+	}
+
+	prog, err := loadProg(srcFile, opts)
+	if err != nil {
+		return printErr(stderr, srcFile, err, false)
+	}
+
+	cov := bef93.NewCoverage(prog)
+	exit := exitOK
+
+	if !*noRun {
+		if len(inputs) == 0 {
+			inputs = stringList{""}
+		}
+
+		for _, input := range inputs {
+			in := io.NopCloser(stdin)
+			if input != "" {
+				in, err = openInput(input)
+				if err != nil {
+					return printErr(stderr, input, err, false)
+				}
+			}
+
+			err = bef93.NewProc(prog, in, stderr, stderr, bef93.WithObserver(cov)).Exec()
+			in.Close()
+			if err != nil {
+				// keep going, the coverage is still useful
+				exit = printErr(stderr, srcFile, err, false)
+			}
+		}
+	}
+
+	for _, fileName := range merge {
+		err = mergeCoverageProfile(cov, prog, fileName)
+		if err != nil {
+			return printErr(stderr, fileName, err, false)
+		}
+	}
+
+	writeCoverage(stdout, prog, cov, *color)
+
+	if *profile != "" {
+		err = writeFile(*profile, cov.WriteProfile)
+		if err != nil {
+			return printErr(stderr, *profile, err, false)
+		}
+	}
+
+	if exit == exitOK && cov.Percent() < *minPercent {
+		fmt.Fprintf(stderr, "%s: coverage %.1f%% is below %.1f%%\n", srcFile, cov.Percent(), *minPercent)
+		return exitCoverageErr
+	}
+
+	return exit
+}
+
+func mergeCoverageProfile(cov *bef93.Coverage, prog *bef93.Prog, fileName string) error {
+	// #nosec G304
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	// #nosec G307
+	defer file.Close()
+
+	// larger profiles can not be merged anyway
+	w, h := prog.Size()
+	other, err := bef93.ReadCoverageProfile(file, w*h)
+	if err != nil {
+		return err
+	}
+	return cov.Merge(other)
+}
+
+// writeCoverage prints the program grid with uncovered cells marked,
+// either by a marker line below each row, or in red.
+func writeCoverage(w io.Writer, prog *bef93.Prog, cov *bef93.Coverage, color bool) {
+	_, h := prog.Size()
+
+	lines := strings.Split(prog.String(), "\n")
+	for i, l := range lines {
+		// first two lines are the top numbering and border
+		y := i - 2
+		if y < 0 || y >= h {
+			fmt.Fprintln(w, l)
+			continue
+		}
+
+		start, end := strings.IndexRune(l, '|'), strings.LastIndex(l, "|")
+		row := []rune(l[start+1 : end])
+		uncovered := func(x int) bool {
+			return cov.Coverable(x, y) && cov.Count(x, y) == 0
+		}
+
+		if !color {
+			fmt.Fprintln(w, l)
+
+			marker := []rune(strings.Repeat(" ", len(row)))
+			found := false
+			for x := range row {
+				if uncovered(x) {
+					marker[x] = '^'
+					found = true
+				}
+			}
+			if found {
+				fmt.Fprintf(w, "%s%s\n", strings.Repeat(" ", start+1), strings.TrimRight(string(marker), " "))
+			}
+			continue
+		}
+
+		b := strings.Builder{}
+		b.WriteString(l[:start+1])
+		for x, r := range row {
+			if uncovered(x) {
+				fmt.Fprintf(&b, "\x1b[41m%c%s", r, ansiReset)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+		b.WriteString(l[end:])
+		fmt.Fprintln(w, b.String())
+	}
+
+	covered, coverable := cov.Covered()
+	fmt.Fprintf(w, "\ncoverage: %d of %d cells (%.1f%%)\n", covered, coverable, cov.Percent())
+}
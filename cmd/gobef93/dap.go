@@ -0,0 +1,650 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"jo-m.ch/go/gobef93/pkg/bef93"
+)
+
+// The DAP server maps Befunge concepts onto the Debug Adapter Protocol:
+// - There is a single thread with a single stack frame, located at the PC.
+// - Grid rows are source lines, grid columns are source columns.
+// - The stack and the interpreter state are variable scopes.
+// - Every instruction is a statement, so all step requests execute one instruction.
+// - Breakpoints with a column are hit before the instruction at that cell is executed,
+//   breakpoints without a column are hit when the PC enters the row.
+
+const (
+	dapThreadID    = 1
+	dapFrameID     = 1
+	dapStackRef    = 1
+	dapStateRef    = 2
+	dapJournalSize = 100000
+)
+
+// dapRequest is a request sent by the client.
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// dapResponse is a response sent to the client.
+type dapResponse struct {
+	Seq        int    `json:"seq"`
+	Type       string `json:"type"`
+	RequestSeq int    `json:"request_seq"`
+	Success    bool   `json:"success"`
+	Command    string `json:"command"`
+	Message    string `json:"message,omitempty"`
+	Body       any    `json:"body,omitempty"`
+}
+
+// dapEvent is an event sent to the client.
+type dapEvent struct {
+	Seq   int    `json:"seq"`
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Body  any    `json:"body,omitempty"`
+}
+
+type dapMessage interface {
+	setSeq(seq int)
+}
+
+func (r *dapResponse) setSeq(seq int) { r.Seq = seq }
+func (e *dapEvent) setSeq(seq int)    { e.Seq = seq }
+
+func newDAPEvent(event string, body any) *dapEvent {
+	return &dapEvent{Type: "event", Event: event, Body: body}
+}
+
+type dapSource struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type dapSourceBreakpoint struct {
+	Line   int  `json:"line"`
+	Column *int `json:"column"`
+}
+
+type dapBreakpoint struct {
+	Verified bool `json:"verified"`
+	Line     int  `json:"line"`
+	Column   int  `json:"column,omitempty"`
+}
+
+type dapStackFrame struct {
+	ID     int       `json:"id"`
+	Name   string    `json:"name"`
+	Source dapSource `json:"source"`
+	Line   int       `json:"line"`
+	Column int       `json:"column"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// dapOutput forwards program output to the client as output events.
+type dapOutput struct {
+	d        *dapServer
+	category string
+}
+
+func (o *dapOutput) Write(b []byte) (int, error) {
+	err := o.d.send(newDAPEvent("output", map[string]any{"category": o.category, "output": string(b)}))
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+type dapServer struct {
+	r *bufio.Reader
+
+	wMu sync.Mutex // guards w and seq
+	w   io.Writer
+	seq int
+
+	lineBase, colBase int
+	src               string
+	proc              *bef93.Proc
+	input             io.Closer
+	stopOnEntry       bool
+	pending           []*dapEvent // events to send after the current response
+
+	// running is closed when the program stops running, nil if not running
+	running chan struct{}
+	cancel  context.CancelFunc
+
+	mu         sync.Mutex // guards the fields below, used by the exec goroutine
+	bps        map[[2]int]bool
+	pause      bool
+	stopReason string
+	lastY      int
+}
+
+func runDAP(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gobef93 dap", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	fs.Usage = func() {
+		w := fs.Output()
+
+		fmt.Fprintf(w, "Usage of %s:\n", fs.Name())
+		fmt.Fprintf(w, `Serves the Debug Adapter Protocol on stdin/stdout.
+Takes no positional arguments, the program is given by the 'program'
+attribute of the launch request. Other launch attributes are 'input'
+(file to read program input from), 'stopOnEntry' and 'opts' (bef93.Opts).
+`)
+
+		fs.PrintDefaults()
+	}
+
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsageErr
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(stderr, "expected no positional arguments\n")
+		fs.Usage()
+		return exitUsageErr
+	}
+
+	err = serveDAP(stdin, stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "dap: %s\n", err)
+		return exitIOErr
+	}
+
+	return exitOK
+}
+
+// serveDAP serves a single DAP session, until the client disconnects.
+func serveDAP(r io.Reader, w io.Writer) error {
+	d := &dapServer{
+		r:        bufio.NewReader(r),
+		w:        w,
+		lineBase: 1,
+		colBase:  1,
+		bps:      map[[2]int]bool{},
+		lastY:    -1,
+	}
+
+	defer func() {
+		d.stop()
+		if d.input != nil {
+			d.input.Close()
+		}
+	}()
+
+	for {
+		req, err := d.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp := &dapResponse{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: true}
+		resp.Body, err = d.handle(req)
+		if err != nil {
+			resp.Success = false
+			resp.Message = err.Error()
+		}
+
+		err = d.send(resp)
+		if err != nil {
+			return err
+		}
+
+		for _, ev := range d.pending {
+			err = d.send(ev)
+			if err != nil {
+				return err
+			}
+		}
+		d.pending = nil
+
+		if req.Command == "disconnect" {
+			return nil
+		}
+		if !resp.Success {
+			continue
+		}
+
+		// only after the response was sent, as the program may stop immediately
+		switch {
+		case req.Command == "continue", req.Command == "configurationDone" && !d.stopOnEntry:
+			d.resume()
+		case req.Command == "pause":
+			d.mu.Lock()
+			d.pause = true
+			d.mu.Unlock()
+		}
+	}
+}
+
+// read reads the next request.
+func (d *dapServer) read() (*dapRequest, error) {
+	header, err := textproto.NewReader(d.r).ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(header) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length '%s'", header.Get("Content-Length"))
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(d.r, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &dapRequest{}
+	err = json.Unmarshal(body, req)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// send writes a message, assigning the next sequence number.
+func (d *dapServer) send(msg dapMessage) error {
+	d.wMu.Lock()
+	defer d.wMu.Unlock()
+
+	d.seq++
+	msg.setSeq(d.seq)
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(d.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (d *dapServer) isRunning() bool {
+	if d.running == nil {
+		return false
+	}
+
+	select {
+	case <-d.running:
+		d.running = nil
+		return false
+	default:
+		return true
+	}
+}
+
+// stop terminates the program if it is running.
+func (d *dapServer) stop() {
+	if d.isRunning() {
+		d.cancel()
+		<-d.running
+		d.running = nil
+	}
+}
+
+// handle executes a request, and returns the response body.
+func (d *dapServer) handle(req *dapRequest) (any, error) {
+	switch req.Command {
+	case "initialize":
+		return d.initialize(req.Arguments)
+	case "launch":
+		return nil, d.launch(req.Arguments)
+	case "setBreakpoints":
+		return d.setBreakpoints(req.Arguments)
+	case "configurationDone":
+		if !d.launched() {
+			return nil, errors.New("no program launched")
+		}
+		if d.stopOnEntry {
+			d.pending = append(d.pending, d.stoppedEvent("entry", ""))
+		}
+		return nil, nil
+	case "threads":
+		return map[string]any{"threads": []map[string]any{{"id": dapThreadID, "name": "main"}}}, nil
+	case "pause":
+		if !d.isRunning() {
+			return nil, errors.New("program is not running")
+		}
+		return nil, nil
+	case "disconnect":
+		d.stop()
+		return nil, nil
+	}
+
+	if !d.launched() {
+		return nil, errors.New("no program launched")
+	}
+	if d.isRunning() {
+		return nil, errors.New("program is running")
+	}
+
+	switch req.Command {
+	case "continue":
+		return map[string]any{"allThreadsContinued": true}, nil
+	case "next", "stepIn", "stepOut":
+		d.step()
+		return nil, nil
+	case "stepBack":
+		err := d.proc.StepBack()
+		if err != nil {
+			return nil, err
+		}
+		d.syncLastY()
+		d.pending = append(d.pending, d.stoppedEvent("step", ""))
+		return nil, nil
+	case "reverseContinue":
+		d.reverseContinue()
+		return nil, nil
+	case "stackTrace":
+		return map[string]any{"stackFrames": []dapStackFrame{d.stackFrame()}, "totalFrames": 1}, nil
+	case "scopes":
+		return map[string]any{"scopes": []map[string]any{
+			{"name": "Stack", "variablesReference": dapStackRef, "expensive": false},
+			{"name": "State", "variablesReference": dapStateRef, "expensive": false},
+		}}, nil
+	case "variables":
+		return d.variables(req.Arguments)
+	}
+
+	return nil, fmt.Errorf("unsupported request '%s'", req.Command)
+}
+
+func (d *dapServer) launched() bool {
+	return d.proc != nil
+}
+
+func (d *dapServer) initialize(args json.RawMessage) (any, error) {
+	init := struct {
+		LinesStartAt1   *bool `json:"linesStartAt1"`
+		ColumnsStartAt1 *bool `json:"columnsStartAt1"`
+	}{}
+	if len(args) > 0 {
+		err := json.Unmarshal(args, &init)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if init.LinesStartAt1 != nil && !*init.LinesStartAt1 {
+		d.lineBase = 0
+	}
+	if init.ColumnsStartAt1 != nil && !*init.ColumnsStartAt1 {
+		d.colBase = 0
+	}
+
+	d.pending = append(d.pending, newDAPEvent("initialized", nil))
+	return map[string]any{
+		"supportsConfigurationDoneRequest": true,
+		"supportsStepBack":                 true,
+	}, nil
+}
+
+func (d *dapServer) launch(args json.RawMessage) error {
+	if d.launched() {
+		return errors.New("program already launched")
+	}
+
+	launch := struct {
+		Program     string     `json:"program"`
+		Input       string     `json:"input"`
+		StopOnEntry bool       `json:"stopOnEntry"`
+		Opts        bef93.Opts `json:"opts"`
+	}{}
+	err := json.Unmarshal(args, &launch)
+	if err != nil {
+		return err
+	}
+	if launch.Program == "" {
+		return errors.New("missing 'program' attribute")
+	}
+
+	prog, err := loadProg(launch.Program, launch.Opts)
+	if err != nil {
+		return errors.New(bef93.FormatDiagnostic(err))
+	}
+
+	in, err := openInput(launch.Input)
+	if err != nil {
+		return err
+	}
+
+	d.input = in
+	d.src = launch.Program
+	d.stopOnEntry = launch.StopOnEntry
+	d.proc = bef93.NewProc(prog, in,
+		&dapOutput{d: d, category: "stdout"}, &dapOutput{d: d, category: "stderr"},
+		bef93.WithJournal(dapJournalSize))
+	d.proc.AddBreakpoint(bef93.CondBreakpoint(d.shouldStop))
+
+	return nil
+}
+
+func (d *dapServer) setBreakpoints(args json.RawMessage) (any, error) {
+	set := struct {
+		Breakpoints []dapSourceBreakpoint `json:"breakpoints"`
+	}{}
+	err := json.Unmarshal(args, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.bps = map[[2]int]bool{}
+	ret := make([]dapBreakpoint, len(set.Breakpoints))
+	for i, bp := range set.Breakpoints {
+		ret[i] = dapBreakpoint{Verified: true, Line: bp.Line}
+
+		x := -1
+		if bp.Column != nil {
+			x = *bp.Column - d.colBase
+			ret[i].Column = *bp.Column
+		}
+		d.bps[[2]int{x, bp.Line - d.lineBase}] = true
+	}
+
+	return map[string]any{"breakpoints": ret}, nil
+}
+
+// shouldStop is called by the proc before each instruction executed by Exec().
+func (d *dapServer) shouldStop(state bef93.ProcState) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entered := state.PCY != d.lastY
+	d.lastY = state.PCY
+
+	switch {
+	case d.pause:
+		d.stopReason = "pause"
+	case d.bps[[2]int{state.PCX, state.PCY}], entered && d.bps[[2]int{-1, state.PCY}]:
+		d.stopReason = "breakpoint"
+	default:
+		return false
+	}
+
+	d.pause = false
+	return true
+}
+
+// syncLastY sets lastY to the current row, after the PC was moved outside
+// of execution, so that row breakpoints do not stop at once when continuing.
+func (d *dapServer) syncLastY() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastY = d.proc.State().PCY
+}
+
+// resume executes the program in the background, until it stops.
+func (d *dapServer) resume() {
+	ctx, cancel := context.WithCancel(context.Background())
+	running := make(chan struct{})
+	d.cancel = cancel
+	d.running = running
+
+	go func() {
+		err := d.proc.ExecContext(ctx)
+		if errors.Is(err, context.Canceled) {
+			close(running)
+			return
+		}
+
+		events := d.stopEvents(err)
+		// allow further requests before the client is notified
+		close(running)
+		for _, ev := range events {
+			_ = d.send(ev)
+		}
+	}()
+}
+
+func (d *dapServer) step() {
+	done, err := d.proc.Step()
+	d.syncLastY()
+	if err == nil && !done {
+		d.pending = append(d.pending, d.stoppedEvent("step", ""))
+		return
+	}
+	d.pending = append(d.pending, d.stopEvents(err)...)
+}
+
+// reverseContinue steps back until a breakpoint is hit or the journal is exhausted.
+func (d *dapServer) reverseContinue() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// row breakpoints must not stop at once when continuing from here
+	defer func() { d.lastY = d.proc.State().PCY }()
+
+	for {
+		y := d.proc.State().PCY
+		if d.proc.StepBack() != nil {
+			break
+		}
+
+		state := d.proc.State()
+		if d.bps[[2]int{state.PCX, state.PCY}] || (state.PCY != y && d.bps[[2]int{-1, state.PCY}]) {
+			d.pending = append(d.pending, d.stoppedEvent("breakpoint", ""))
+			return
+		}
+	}
+
+	d.pending = append(d.pending, d.stoppedEvent("step", ""))
+}
+
+// stopEvents returns the events to send after execution stopped with err.
+func (d *dapServer) stopEvents(err error) []*dapEvent {
+	switch {
+	case err == nil:
+		return []*dapEvent{
+			newDAPEvent("exited", map[string]any{"exitCode": exitOK}),
+			newDAPEvent("terminated", nil),
+		}
+	case errors.Is(err, bef93.ErrTerminated):
+		return []*dapEvent{newDAPEvent("terminated", nil)}
+	case errors.Is(err, bef93.ErrBreakpoint):
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return []*dapEvent{d.stoppedEvent(d.stopReason, "")}
+	default:
+		return []*dapEvent{
+			newDAPEvent("output", map[string]any{"category": "stderr", "output": bef93.FormatDiagnostic(err) + "\n"}),
+			d.stoppedEvent("exception", err.Error()),
+		}
+	}
+}
+
+func (d *dapServer) stoppedEvent(reason, text string) *dapEvent {
+	body := map[string]any{"reason": reason, "threadId": dapThreadID, "allThreadsStopped": true}
+	if text != "" {
+		body["text"] = text
+	}
+	return newDAPEvent("stopped", body)
+}
+
+func (d *dapServer) stackFrame() dapStackFrame {
+	state := d.proc.State()
+
+	return dapStackFrame{
+		ID:     dapFrameID,
+		Name:   fmt.Sprintf("'%c' at (%d, %d)", d.proc.Prog().Cell(state.PCX, state.PCY), state.PCX, state.PCY),
+		Source: dapSource{Name: filepath.Base(d.src), Path: d.src},
+		Line:   state.PCY + d.lineBase,
+		Column: state.PCX + d.colBase,
+	}
+}
+
+func (d *dapServer) variables(args json.RawMessage) (any, error) {
+	vars := struct {
+		VariablesReference int `json:"variablesReference"`
+	}{}
+	err := json.Unmarshal(args, &vars)
+	if err != nil {
+		return nil, err
+	}
+
+	state := d.proc.State()
+	ret := []dapVariable{}
+
+	switch vars.VariablesReference {
+	case dapStackRef:
+		for i := len(state.Stack) - 1; i >= 0; i-- {
+			ret = append(ret, dapVariable{Name: strconv.Itoa(len(state.Stack) - 1 - i), Value: dapValue(state.Stack[i])})
+		}
+	case dapStateRef:
+		strMode := "off"
+		if state.StrMode {
+			strMode = "on"
+		}
+		ret = append(ret,
+			dapVariable{Name: "pc", Value: fmt.Sprintf("(%d, %d)", state.PCX, state.PCY)},
+			dapVariable{Name: "direction", Value: state.Dir.String()},
+			dapVariable{Name: "string mode", Value: strMode},
+			dapVariable{Name: "steps", Value: strconv.FormatInt(state.Steps, 10)},
+		)
+	default:
+		return nil, fmt.Errorf("unknown variables reference %d", vars.VariablesReference)
+	}
+
+	return map[string]any{"variables": ret}, nil
+}
+
+// dapValue formats a stack value, including the character if printable.
+func dapValue(v int64) string {
+	if v >= ' ' && v <= '~' {
+		return fmt.Sprintf("%d %s", v, strconv.QuoteRune(rune(v)))
+	}
+	return strconv.FormatInt(v, 10)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"testing"
+)
+
+// dapTestMessage is any message received from the server.
+type dapTestMessage struct {
+	Type       string         `json:"type"`
+	RequestSeq int            `json:"request_seq"`
+	Success    bool           `json:"success"`
+	Command    string         `json:"command"`
+	Message    string         `json:"message"`
+	Event      string         `json:"event"`
+	Body       map[string]any `json:"body"`
+}
+
+// dapClient is a scripted DAP client, talking to serveDAP() via pipes.
+type dapClient struct {
+	t    *testing.T
+	w    io.WriteCloser
+	r    *bufio.Reader
+	seq  int
+	done chan error
+}
+
+func newDAPClient(t *testing.T) *dapClient {
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+
+	c := &dapClient{t: t, w: reqW, r: bufio.NewReader(respR), done: make(chan error, 1)}
+	go func() {
+		err := serveDAP(reqR, respW)
+		respW.Close()
+		c.done <- err
+	}()
+
+	return c
+}
+
+func (c *dapClient) send(cmd string, args any) {
+	c.seq++
+	body, err := json.Marshal(map[string]any{"seq": c.seq, "type": "request", "command": cmd, "arguments": args})
+	if err != nil {
+		c.t.Fatalf(err.Error())
+	}
+
+	_, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	if err != nil {
+		c.t.Fatalf(err.Error())
+	}
+}
+
+func (c *dapClient) read() dapTestMessage {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf(err.Error())
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatalf(err.Error())
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(c.r, body)
+	if err != nil {
+		c.t.Fatalf(err.Error())
+	}
+
+	msg := dapTestMessage{}
+	err = json.Unmarshal(body, &msg)
+	if err != nil {
+		c.t.Fatalf(err.Error())
+	}
+	return msg
+}
+
+// request sends a request and returns the body of the successful response.
+func (c *dapClient) request(cmd string, args any) map[string]any {
+	c.send(cmd, args)
+
+	msg := c.read()
+	if msg.Type != "response" || msg.RequestSeq != c.seq || msg.Command != cmd {
+		c.t.Fatalf("unexpected message %+v", msg)
+	}
+	if !msg.Success {
+		c.t.Fatalf("request '%s' failed: %s", cmd, msg.Message)
+	}
+	return msg.Body
+}
+
+// event reads the next message, which must be the given event.
+func (c *dapClient) event(event string) map[string]any {
+	msg := c.read()
+	if msg.Type != "event" || msg.Event != event {
+		c.t.Fatalf("expected event '%s', got %+v", event, msg)
+	}
+	return msg.Body
+}
+
+// stopped reads the next event, which must be a stopped event with the given reason.
+func (c *dapClient) stopped(reason string) {
+	body := c.event("stopped")
+	if body["reason"] != reason {
+		c.t.Fatalf("expected stop reason '%s', got %+v", reason, body)
+	}
+}
+
+// location returns the line and column of the top stack frame.
+func (c *dapClient) location() (int, int) {
+	body := c.request("stackTrace", map[string]any{"threadId": dapThreadID})
+	frame := body["stackFrames"].([]any)[0].(map[string]any)
+	return int(frame["line"].(float64)), int(frame["column"].(float64))
+}
+
+// variables returns the variables of a scope, as name/value pairs.
+func (c *dapClient) variables(ref int) [][2]string {
+	body := c.request("variables", map[string]any{"variablesReference": ref})
+
+	ret := [][2]string{}
+	for _, v := range body["variables"].([]any) {
+		v := v.(map[string]any)
+		ret = append(ret, [2]string{v["name"].(string), v["value"].(string)})
+	}
+	return ret
+}
+
+func (c *dapClient) disconnect() {
+	c.request("disconnect", nil)
+
+	err := <-c.done
+	if err != nil {
+		c.t.Fatalf(err.Error())
+	}
+}
+
+func Test_serveDAP(t *testing.T) {
+	src := writeSrc(t, `"a",v
+ @.2<`)
+
+	c := newDAPClient(t)
+
+	caps := c.request("initialize", map[string]any{"adapterID": "gobef93", "linesStartAt1": true, "columnsStartAt1": true})
+	if caps["supportsStepBack"] != true {
+		t.Fatal("should support step back")
+	}
+	c.event("initialized")
+
+	c.request("launch", map[string]any{"program": src, "stopOnEntry": true})
+
+	bps := c.request("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": src},
+		"breakpoints": []any{map[string]any{"line": 2, "column": 3}},
+	})
+	if len(bps["breakpoints"].([]any)) != 1 {
+		t.Fatal("should be equal")
+	}
+
+	c.request("configurationDone", nil)
+	c.stopped("entry")
+
+	if line, col := c.location(); line != 1 || col != 1 {
+		t.Fatalf("invalid location %d:%d", line, col)
+	}
+
+	c.request("next", map[string]any{"threadId": dapThreadID})
+	c.stopped("step")
+	if line, col := c.location(); line != 1 || col != 2 {
+		t.Fatalf("invalid location %d:%d", line, col)
+	}
+
+	c.request("continue", map[string]any{"threadId": dapThreadID})
+	if c.event("output")["output"] != "a" {
+		t.Fatal("should be equal")
+	}
+	c.stopped("breakpoint")
+	if line, col := c.location(); line != 2 || col != 3 {
+		t.Fatalf("invalid location %d:%d", line, col)
+	}
+
+	scopes := c.request("scopes", map[string]any{"frameId": dapFrameID})
+	if len(scopes["scopes"].([]any)) != 2 {
+		t.Fatal("should be equal")
+	}
+	if vars := c.variables(dapStackRef); len(vars) != 1 || vars[0] != [2]string{"0", "2"} {
+		t.Fatalf("invalid stack %v", vars)
+	}
+	if vars := c.variables(dapStateRef); vars[1] != [2]string{"direction", "left"} || vars[3] != [2]string{"steps", "7"} {
+		t.Fatalf("invalid state %v", vars)
+	}
+
+	c.request("stepBack", map[string]any{"threadId": dapThreadID})
+	c.stopped("step")
+	if line, col := c.location(); line != 2 || col != 4 {
+		t.Fatalf("invalid location %d:%d", line, col)
+	}
+
+	c.request("continue", map[string]any{"threadId": dapThreadID})
+	c.stopped("breakpoint")
+
+	c.request("reverseContinue", map[string]any{"threadId": dapThreadID})
+	c.stopped("step")
+	if line, col := c.location(); line != 1 || col != 1 {
+		t.Fatalf("invalid location %d:%d", line, col)
+	}
+
+	c.request("continue", map[string]any{"threadId": dapThreadID})
+	c.event("output")
+	c.stopped("breakpoint")
+
+	c.request("continue", map[string]any{"threadId": dapThreadID})
+	if c.event("output")["output"] != "2 " {
+		t.Fatal("should be equal")
+	}
+	c.event("exited")
+	c.event("terminated")
+
+	c.disconnect()
+}
+
+func Test_serveDAP_PauseAndRowBreakpoint(t *testing.T) {
+	src := writeSrc(t, `>v
+^<`)
+
+	c := newDAPClient(t)
+
+	c.request("initialize", map[string]any{"adapterID": "gobef93"})
+	c.event("initialized")
+	c.request("launch", map[string]any{"program": src})
+	c.request("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": src},
+		"breakpoints": []any{map[string]any{"line": 2}},
+	})
+
+	c.request("configurationDone", nil)
+	c.stopped("breakpoint")
+	if line, col := c.location(); line != 2 || col != 2 {
+		t.Fatalf("invalid location %d:%d", line, col)
+	}
+
+	// not hit again while the PC stays on the row
+	c.request("continue", map[string]any{"threadId": dapThreadID})
+	c.stopped("breakpoint")
+	if vars := c.variables(dapStateRef); vars[3] != [2]string{"steps", "6"} {
+		t.Fatalf("invalid state %v", vars)
+	}
+
+	c.request("setBreakpoints", map[string]any{"source": map[string]any{"path": src}, "breakpoints": []any{}})
+	c.request("continue", map[string]any{"threadId": dapThreadID})
+	c.request("pause", map[string]any{"threadId": dapThreadID})
+	c.stopped("pause")
+
+	c.send("stepOver", nil)
+	if msg := c.read(); msg.Success || msg.Message != "unsupported request 'stepOver'" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+
+	c.request("continue", map[string]any{"threadId": dapThreadID})
+	c.disconnect()
+}
+
+func Test_serveDAP_StepThenContinue(t *testing.T) {
+	src := writeSrc(t, `v
+>1.@`)
+
+	c := newDAPClient(t)
+
+	c.request("initialize", map[string]any{"adapterID": "gobef93"})
+	c.event("initialized")
+	c.request("launch", map[string]any{"program": src, "stopOnEntry": true})
+	c.request("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": src},
+		"breakpoints": []any{map[string]any{"line": 2}},
+	})
+
+	c.request("configurationDone", nil)
+	c.stopped("entry")
+
+	// step onto the row with the breakpoint
+	c.request("next", map[string]any{"threadId": dapThreadID})
+	c.stopped("step")
+	if line, col := c.location(); line != 2 || col != 1 {
+		t.Fatalf("invalid location %d:%d", line, col)
+	}
+
+	// the PC does not enter the row again
+	c.request("continue", map[string]any{"threadId": dapThreadID})
+	if c.event("output")["output"] != "1 " {
+		t.Fatal("should be equal")
+	}
+	c.event("exited")
+	c.event("terminated")
+
+	c.disconnect()
+}
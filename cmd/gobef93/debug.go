@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"jo-m.ch/go/gobef93/pkg/bef93"
+)
+
+const debugHelp = `Commands:
+  step [n], s [n]        execute n instructions (default 1), ignoring breakpoints
+  back [n], b [n]        step back n instructions (default 1)
+  continue, c            execute until a breakpoint is hit or the program terminates
+  break x,y              set a breakpoint at cell (x, y)
+  breakop c              set a breakpoint on every execution of instruction c
+  watch x0,y0[,x1,y1]    stop when 'p' writes to a cell or a region
+  breakpoints            list breakpoints
+  delete n               delete breakpoint n
+  stack                  show the stack, top first
+  grid                   show the program grid, with the PC highlighted
+  input [n]              preview the next n bytes of input (default 16)
+  where                  show the PC and interpreter state
+  help                   show this help
+  quit, q                exit the debugger`
+
+// openInput opens the program input file, or returns an empty reader if fileName is empty.
+// The caller must close it.
+func openInput(fileName string) (io.ReadCloser, error) {
+	if fileName == "" {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	// #nosec G304
+	return os.Open(fileName)
+}
+
+func runDebug(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gobef93 debug", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	opts := bef93.Opts{}
+	registerOptsFlags(fs, &opts)
+
+	inputFile := fs.String("input", "", "File to read program input from. If empty, the program reads no input.")
+	journal := fs.Int("journal", 100000, "Maximum number of steps which can be stepped back.")
+
+	fs.Usage = func() {
+		w := fs.Output()
+
+		fmt.Fprintf(w, "Usage of %s:\n", fs.Name())
+		fmt.Fprintf(w, `Interactively debugs a Befunge-93 program file.
+Takes a single positional argument, which is the file to debug.
+Debugger commands are read from stdin, line by line.
+Program output is written to stdout, interleaved with debugger output.
+
+%s
+`, debugHelp)
+
+		fs.PrintDefaults()
+	}
+
+	srcFile, code, ok := parseFileArg(fs, args)
+	if !ok {
+		return code
+	}
+
+	prog, err := loadProg(srcFile, opts)
+	if err != nil {
+		return printErr(stderr, srcFile, err, false)
+	}
+
+	in, err := openInput(*inputFile)
+	if err != nil {
+		return printErr(stderr, *inputFile, err, false)
+	}
+	// #nosec G307
+	defer in.Close()
+
+	d := &debugger{
+		proc: bef93.NewProc(prog, in, stdout, stderr, bef93.WithJournal(*journal)),
+		out:  stdout,
+	}
+	d.repl(stdin)
+
+	return exitOK
+}
+
+type debugger struct {
+	proc *bef93.Proc
+	out  io.Writer
+}
+
+// repl reads and executes commands until quit or EOF.
+func (d *debugger) repl(cmds io.Reader) {
+	scanner := bufio.NewScanner(cmds)
+
+	d.where()
+	for {
+		fmt.Fprint(d.out, "(bef93) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(d.out)
+			return
+		}
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		cmd, args := fields[0], strings.Join(fields[1:], " ")
+		if cmd == "quit" || cmd == "q" {
+			return
+		}
+
+		err := d.exec(cmd, args)
+		if err != nil {
+			fmt.Fprintf(d.out, "error: %s\n", err)
+		}
+	}
+}
+
+func (d *debugger) exec(cmd, args string) error {
+	switch cmd {
+	case "step", "s":
+		n, err := parseCount(args, 1)
+		if err != nil {
+			return err
+		}
+		_, err = d.proc.StepN(n)
+		d.stopped(err)
+	case "back", "b":
+		n, err := parseCount(args, 1)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			err := d.proc.StepBack()
+			if err != nil {
+				return err
+			}
+		}
+		d.where()
+	case "continue", "c":
+		d.stopped(d.proc.Exec())
+	case "break":
+		xy, err := parseInts(args, 2, 2)
+		if err != nil {
+			return err
+		}
+		d.addBreakpoint(bef93.CellBreakpoint(xy[0], xy[1]))
+	case "breakop":
+		r := []rune(args)
+		if len(r) != 1 {
+			return errors.New("expected a single instruction")
+		}
+		d.addBreakpoint(bef93.OpBreakpoint(r[0]))
+	case "watch":
+		xy, err := parseInts(args, 2, 4)
+		if err != nil {
+			return err
+		}
+		if len(xy) == 2 {
+			xy = append(xy, xy...)
+		}
+		if len(xy) != 4 {
+			return errors.New("expected x0,y0 or x0,y0,x1,y1")
+		}
+		d.addBreakpoint(bef93.Watchpoint(xy[0], xy[1], xy[2], xy[3]))
+	case "breakpoints":
+		for i, bp := range d.proc.Breakpoints() {
+			fmt.Fprintf(d.out, "%d: %s\n", i, bp)
+		}
+	case "delete":
+		n, err := parseInts(args, 1, 1)
+		if err != nil {
+			return err
+		}
+		bps := d.proc.Breakpoints()
+		if n[0] < 0 || n[0] >= len(bps) {
+			return fmt.Errorf("no breakpoint %d", n[0])
+		}
+		d.proc.RemoveBreakpoint(bps[n[0]])
+	case "stack":
+		d.stack()
+	case "grid":
+		d.grid()
+	case "input":
+		n, err := parseCount(args, 16)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(d.out, "%q\n", d.proc.PeekInput(n))
+	case "where":
+		d.where()
+	case "help":
+		fmt.Fprintln(d.out, debugHelp)
+	default:
+		return fmt.Errorf("unknown command '%s', type 'help' for a list of commands", cmd)
+	}
+
+	return nil
+}
+
+func (d *debugger) addBreakpoint(bp *bef93.Breakpoint) {
+	d.proc.AddBreakpoint(bp)
+	fmt.Fprintf(d.out, "%d: %s\n", len(d.proc.Breakpoints())-1, bp)
+}
+
+// stopped reports why execution stopped.
+func (d *debugger) stopped(err error) {
+	if errors.Is(err, bef93.ErrTerminated) {
+		fmt.Fprintln(d.out, "program has already terminated")
+		return
+	}
+
+	if errors.Is(err, bef93.ErrBreakpoint) {
+		var rerr *bef93.RuntimeError
+		errors.As(err, &rerr)
+		fmt.Fprintf(d.out, "hit %s\n", rerr.Msg)
+	} else if err != nil {
+		fmt.Fprintln(d.out, bef93.FormatDiagnostic(err))
+		return
+	}
+
+	d.where()
+}
+
+func (d *debugger) where() {
+	state := d.proc.State()
+	if state.Done {
+		fmt.Fprintf(d.out, "program terminated after %d steps\n", state.Steps)
+		return
+	}
+
+	strMode := "off"
+	if state.StrMode {
+		strMode = "on"
+	}
+	fmt.Fprintf(d.out, "at (%d, %d) '%c', direction: %s, string mode: %s, steps: %d\n",
+		state.PCX, state.PCY, d.proc.Prog().Cell(state.PCX, state.PCY), state.Dir, strMode, state.Steps)
+}
+
+func (d *debugger) stack() {
+	stack := d.proc.State().Stack
+
+	fmt.Fprintf(d.out, "%d values, top first:", len(stack))
+	for i := len(stack) - 1; i >= 0; i-- {
+		fmt.Fprintf(d.out, " %d", stack[i])
+	}
+	fmt.Fprintln(d.out)
+}
+
+// grid prints the program grid, with the PC cell in reverse video.
+func (d *debugger) grid() {
+	state := d.proc.State()
+	lines := strings.Split(d.proc.Prog().String(), "\n")
+
+	for i, l := range lines {
+		// first two lines are the top numbering and border
+		if i != state.PCY+2 {
+			fmt.Fprintln(d.out, l)
+			continue
+		}
+
+		start := strings.IndexRune(l, '|') + 1
+		row := []rune(l[start:])
+		fmt.Fprintf(d.out, "%s%s%s%c%s%s\n", l[:start], string(row[:state.PCX]),
+			ansiReverse, row[state.PCX], ansiReset, string(row[state.PCX+1:]))
+	}
+}
+
+// parseCount parses an optional positive count argument.
+func parseCount(args string, def int) (int, error) {
+	if args == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(args)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid count '%s'", args)
+	}
+	return n, nil
+}
+
+// parseInts parses between lo and hi integers separated by commas or spaces.
+func parseInts(args string, lo, hi int) ([]int, error) {
+	fields := strings.FieldsFunc(args, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(fields) < lo || len(fields) > hi {
+		return nil, fmt.Errorf("invalid arguments '%s'", args)
+	}
+
+	ret := make([]int, len(fields))
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s'", f)
+		}
+		ret[i] = n
+	}
+	return ret, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_runDebug(t *testing.T) {
+	src := writeSrc(t, `v
+>"ab"12 0p&.@`)
+
+	input := filepath.Join(t.TempDir(), "input")
+	err := os.WriteFile(input, []byte("7\n"), 0o600)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	cmds := strings.Join([]string{
+		"step",
+		"s 3",
+		"stack",
+		"break 8,1",
+		"watch 0,0,3,3",
+		"c",
+		"c",
+		"input 1",
+		"b 2",
+		"c",
+		"c",
+		"step",
+		"foo",
+		"q",
+	}, "\n")
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	exit := run([]string{"debug", "-input", input, src}, strings.NewReader(cmds), stdout, stderr)
+	if exit != exitOK {
+		t.Fatalf("invalid exit code %d, stderr: %s", exit, stderr.String())
+	}
+
+	expected := `at (0, 0) 'v', direction: right, string mode: off, steps: 0
+(bef93) at (0, 1) '>', direction: down, string mode: off, steps: 1
+(bef93) at (3, 1) 'b', direction: right, string mode: on, steps: 4
+(bef93) 1 values, top first: 97
+(bef93) 0: cell (8, 1)
+(bef93) 1: watch (0, 0)-(3, 3)
+(bef93) hit breakpoint: cell (8, 1)
+at (8, 1) '0', direction: right, string mode: off, steps: 9
+(bef93) hit breakpoint: watch (0, 0)-(3, 3): write to (2, 0)
+at (10, 1) '&', direction: right, string mode: off, steps: 11
+(bef93) "7"
+(bef93) at (8, 1) '0', direction: right, string mode: off, steps: 9
+(bef93) hit breakpoint: watch (0, 0)-(3, 3): write to (2, 0)
+at (10, 1) '&', direction: right, string mode: off, steps: 11
+(bef93) 7 program terminated after 14 steps
+(bef93) program has already terminated
+(bef93) error: unknown command 'foo', type 'help' for a list of commands
+(bef93) `
+
+	if stdout.String() != expected {
+		t.Fatalf("should be equal:\n%s", stdout.String())
+	}
+}
+
+func Test_runDebug_Grid(t *testing.T) {
+	src := writeSrc(t, `1 v
+  @`)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	exit := run([]string{"debug", src}, strings.NewReader("s 3\ngrid\n"), stdout, stderr)
+	if exit != exitOK {
+		t.Fatalf("invalid exit code %d, stderr: %s", exit, stderr.String())
+	}
+
+	expected := "  0|1 v                                                                             |  0\n" +
+		"  1|  \x1b[7m@\x1b[0m                                                                             |  1\n" +
+		"  2|"
+	if !strings.Contains(stdout.String(), expected) {
+		t.Fatalf("should contain marker:\n%s", stdout.String())
+	}
+}
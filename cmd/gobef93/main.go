@@ -4,88 +4,333 @@ Package main is a simple CLI frontend for the gobef93 Befunge interpreter.
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"jo-m.ch/go/gobef93/pkg/bef93"
 )
 
+// Exit codes, so that scripts can tell different kinds of failures apart.
+const (
+	exitOK         = 0
+	exitRuntimeErr = 1
+	exitUsageErr   = 2
+	exitCompileErr = 3
+	exitIOErr      = 4
+	// returned by 'cover' if the coverage is below the threshold
+	exitCoverageErr = 5
+)
+
 type mainOpts struct {
-	printProg bool
+	printProg   bool
+	plainErrors bool
+	input       string
+	visualize   bool
+	delay       time.Duration
+	trace       string
+	record      string
+	replay      string
+	precompiled bool
 }
 
-func mustParseFlags() (string, bef93.Opts, mainOpts) {
-	opts := bef93.Opts{}
+func registerOptsFlags(fs *flag.FlagSet, opts *bef93.Opts) {
+	fs.BoolVar(&opts.NoFixOffByOne, "no_fix_off_by_one", false, "If true, 'p' and 'g' accept coordinates one past the right and bottom edge, like old versions of the reference implementation did. Befunge 93 standard option.")
+	fs.BoolVar(&opts.ReadErrorUndefined, "read_error_undefined", false, "If true, & will push an undefined number to stack instead of -1. Befunge 93 standard option.")
+	fs.BoolVar(&opts.IgnoreUnsupportedInstructions, "ignore_unsupported_instructions", false, "If true, unsupported instructions will be ignored. Befunge 93 standard option.")
+	fs.BoolVar(&opts.WrapLongLines, "wrap_long_lines", false, "If true, lines longer than the standard width are wrapped onto the next row instead of being rejected. Rows past the standard height are dropped, unless -allow_arbitrary_code_size is set. Befunge 93 standard option.")
+	fs.BoolVar(&opts.WrapHashInconsistently, "wrap_hash_inconsistently", false, "If true, '#' at the edge of the playfield wraps like in the reference implementation. Befunge 93 standard option.")
 
-	flag.BoolVar(&opts.ReadErrorUndefined, "read_error_undefined", false, "If true, & will push an undefined number to stack instead of -1. Befunge 93 standard option.")
-	flag.BoolVar(&opts.IgnoreUnsupportedInstructions, "ignore_unsupported_instructions", false, "If true, unsupported instructions will be ignored. Befunge 93 standard option.")
+	fs.BoolVar(&opts.AllowArbitraryCodeSize, "allow_arbitrary_code_size", false, "Allow code of arbitrary size, code smaller than standard size will be padded to standard size. Non standard option.")
+	fs.BoolVar(&opts.AllowUnicode, "allow_unicode", false, "Allow unicode in the interpreted code. Non standard option.")
+	fs.BoolVar(&opts.DisallowDivZero, "disallow_div_zero", false, "Terminate on division by 0. Non standard option.")
+	fs.Int64Var(&opts.RandSeed, "rand_seed", 0, "Fixed random seed. If 0, the generator is seeded randomly internally. Non standard option.")
+	fs.BoolVar(&opts.TerminateOnIOErr, "terminate_on_io_err", false, "Terminate on I/O errors instead of ignoring them. Non standard option.")
+	fs.BoolVar(&opts.TerminateOnPutGetOutOfBounds, "terminate_on_put_get_out_of_bounds", false, "Terminate if a 'g' or 'p' operation is out of bounds, instead of pushing 0 or discading the pop() value. Non standard option.")
+	fs.Int64Var(&opts.MaxSteps, "max_steps", 0, "Maximum number of instructions to execute before terminating. If 0, there is no limit. Non standard option.")
+	fs.IntVar(&opts.MaxStackDepth, "max_stack_depth", 0, "Maximum number of values on the stack before terminating. If 0, there is no limit. Non standard option.")
+	fs.IntVar(&opts.MaxCodeCells, "max_code_cells", 0, "Maximum number of cells (width * height) of the program grid. If 0, there is no limit. Non standard option.")
+}
 
-	flag.BoolVar(&opts.AllowArbitraryCodeSize, "allow_arbitrary_code_size", false, "Allow code of arbitrary size, code smaller than standard size will be padded to standard size. Non standard option.")
-	flag.BoolVar(&opts.AllowUnicode, "allow_unicode", false, "Allow unicode in the interpreted code. Non standard option.")
-	flag.BoolVar(&opts.DisallowDivZero, "disallow_div_zero", false, "Terminate on division by 0. Non standard option.")
-	flag.Int64Var(&opts.RandSeed, "rand_seed", 0, "Fixed random seed. If 0, the generator is seeded randomly internally. Non standard option.")
-	flag.BoolVar(&opts.TerminateOnIOErr, "terminate_on_io_err", false, "Terminate on I/O errors instead of ignoring them. Non standard option.")
-	flag.BoolVar(&opts.TerminateOnPutGetOutOfBounds, "terminate_on_put_get_out_of_bounds", false, "Terminate if a 'g' or 'p' operation is out of bounds, instead of pushing 0 or discading the pop() value. Non standard option.")
+// parseFlags parses the command line arguments.
+// Returns the exit code to use and false if the program should exit.
+func parseFlags(args []string, stderr io.Writer) (string, bef93.Opts, mainOpts, int, bool) {
+	fs := flag.NewFlagSet("gobef93", flag.ContinueOnError)
+	fs.SetOutput(stderr)
 
-	mainOpts := mainOpts{}
+	opts := bef93.Opts{}
+	registerOptsFlags(fs, &opts)
 
-	flag.BoolVar(&mainOpts.printProg, "print_prog", false, "Print program grid to stderr before execution. Non standard option.")
+	mainOpts := mainOpts{}
+	fs.BoolVar(&mainOpts.printProg, "print_prog", false, "Print program grid to stderr before execution. Non standard option.")
+	fs.BoolVar(&mainOpts.plainErrors, "plain_errors", false, "Print errors as a single line, without code excerpt and interpreter state. Non standard option.")
+	fs.StringVar(&mainOpts.input, "input", "", "File to read program input from. If empty, stdin is used, or no input when visualizing.")
+	fs.BoolVar(&mainOpts.visualize, "visualize", false, "Visualize execution in the terminal, step by step. Keys are read from stdin.")
+	fs.DurationVar(&mainOpts.delay, "delay", 50*time.Millisecond, "Delay per step when visualizing.")
+	fs.StringVar(&mainOpts.record, "record", "", "File to write a replay log to, holding all input values and random directions consumed by the program.")
+	fs.StringVar(&mainOpts.replay, "replay", "", "Replay log to read input values and random directions from, instead of reading input or choosing randomly.")
+	fs.StringVar(&mainOpts.trace, "trace", "", "File to write an execution trace to, one JSON record per executed instruction. See bef93.TraceRecord.")
+	fs.BoolVar(&mainOpts.precompiled, "precompiled", false, "Use the precompiled execution engine, which is faster. See bef93.WithPrecompiled().")
 
-	flag.Usage = func() {
-		w := flag.CommandLine.Output()
+	fs.Usage = func() {
+		w := fs.Output()
 
-		fmt.Fprintf(w, "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(w, "Usage of %s:\n", fs.Name())
 		fmt.Fprintf(w, `Executes a Befunge-93 program file.
 Takes a single positional argument, which is the file to execute.
-For more details on the options, see the docstrings on the bef93.Opts struct.`+"\n")
+For more details on the options, see the docstrings on the bef93.Opts struct.
+
+Subcommands:
+  run      execute a program (default)
+  debug    interactively debug a program
+  dap      serve the Debug Adapter Protocol on stdin/stdout
+  profile  report how often each cell and instruction is executed
+  cover    report which cells are executed, across multiple runs
+Run '%s <subcommand> -help' for details.`+"\n", fs.Name())
 
-		flag.PrintDefaults()
+		fs.PrintDefaults()
 	}
 
-	flag.Parse()
-	if flag.NArg() == 0 {
-		fmt.Fprintf(flag.CommandLine.Output(), "missing positional argument (file name)\n")
-		flag.Usage()
-		os.Exit(1)
+	fileName, code, ok := parseFileArg(fs, args)
+	return fileName, opts, mainOpts, code, ok
+}
+
+// parseFileArg parses args using fs, expecting a single positional argument (the file name).
+// Returns the exit code to use and false if the program should exit.
+func parseFileArg(fs *flag.FlagSet, args []string) (string, int, bool) {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return "", exitOK, false
+	}
+	if err != nil {
+		return "", exitUsageErr, false
 	}
 
-	return flag.Arg(0), opts, mainOpts
+	if fs.NArg() != 1 {
+		fmt.Fprintf(fs.Output(), "expected exactly one positional argument (file name)\n")
+		fs.Usage()
+		return "", exitUsageErr, false
+	}
+
+	return fs.Arg(0), exitOK, true
 }
 
-func mustGetCode(fileName string) string {
+func getCode(fileName string) (string, error) {
 	// #nosec G304
 	file, err := os.Open(fileName)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	// #nosec G307
 	defer file.Close()
 
 	code, err := io.ReadAll(file)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return string(code)
+	return string(code), nil
 }
 
-func main() {
-	srcFile, opts, mainOpts := mustParseFlags()
-	code := mustGetCode(srcFile)
+// printErr prints a diagnostic for err and returns the matching exit code.
+func printErr(stderr io.Writer, fileName string, err error, plain bool) int {
+	msg := bef93.FormatDiagnostic(err)
+	if plain {
+		msg = err.Error()
+	}
+
+	var cerr *bef93.CompilationError
+	if plain && errors.As(err, &cerr) {
+		msg = fmt.Sprintf("compilation error at (%d, %d): %s", cerr.LocX, cerr.LocY, cerr.Msg)
+	}
+
+	fmt.Fprintf(stderr, "%s: %s\n", fileName, msg)
 
-	prog, err := bef93.NewProg(code, opts)
+	var rerr *bef93.RuntimeError
+	switch {
+	case errors.As(err, &cerr):
+		return exitCompileErr
+	case errors.Is(err, bef93.ErrIO):
+		// with -terminate_on_io_err
+		return exitIOErr
+	case errors.As(err, &rerr):
+		return exitRuntimeErr
+	default:
+		return exitIOErr
+	}
+}
+
+// loadProg reads and compiles a program file.
+func loadProg(fileName string, opts bef93.Opts) (*bef93.Prog, error) {
+	src, err := getCode(fileName)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	return bef93.NewProg(src, opts)
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "run":
+			return runExec(args[1:], stdin, stdout, stderr)
+		case "debug":
+			return runDebug(args[1:], stdin, stdout, stderr)
+		case "dap":
+			return runDAP(args[1:], stdin, stdout, stderr)
+		case "profile":
+			return runProfile(args[1:], stdin, stdout, stderr)
+		case "cover":
+			return runCover(args[1:], stdin, stdout, stderr)
+		}
+	}
+
+	return runExec(args, stdin, stdout, stderr)
+}
+
+func runExec(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	srcFile, opts, mainOpts, code, ok := parseFlags(args, stderr)
+	if !ok {
+		return code
+	}
+
+	prog, err := loadProg(srcFile, opts)
+	if err != nil {
+		return printErr(stderr, srcFile, err, mainOpts.plainErrors)
 	}
 
 	if mainOpts.printProg {
-		fmt.Fprintln(os.Stderr, prog.String())
+		fmt.Fprintln(stderr, prog.String())
+	}
+
+	in := stdin
+	if mainOpts.input != "" || mainOpts.visualize {
+		file, err := openInput(mainOpts.input)
+		if err != nil {
+			return printErr(stderr, mainOpts.input, err, mainOpts.plainErrors)
+		}
+		// #nosec G307
+		defer file.Close()
+		in = file
+	}
+
+	procOpts := []bef93.ProcOption{}
+	if mainOpts.precompiled {
+		procOpts = append(procOpts, bef93.WithPrecompiled())
+	}
+	var trace *traceFile
+	if mainOpts.trace != "" {
+		trace, err = createTrace(mainOpts.trace)
+		if err != nil {
+			return printErr(stderr, mainOpts.trace, err, mainOpts.plainErrors)
+		}
+		procOpts = append(procOpts, bef93.WithObserver(trace.tracer))
 	}
 
-	proc := bef93.NewProc(prog, os.Stdin, os.Stdout, os.Stderr)
-	err = proc.Exec()
+	if mainOpts.replay != "" {
+		log, err := readReplayLog(mainOpts.replay)
+		if err != nil {
+			return printErr(stderr, mainOpts.replay, err, mainOpts.plainErrors)
+		}
+		procOpts = append(procOpts, bef93.WithReplay(log))
+	}
+
+	var record *bef93.ReplayLog
+	if mainOpts.record != "" {
+		record = &bef93.ReplayLog{}
+		procOpts = append(procOpts, bef93.WithRecording(record))
+	}
+
+	if mainOpts.visualize {
+		if f, ok := stdin.(*os.File); ok {
+			restore, err := makeRaw(f.Fd())
+			if err == nil {
+				defer restore()
+			}
+		}
+
+		err = runVisualized(prog, in, stdin, stdout, mainOpts.delay, procOpts...)
+	} else {
+		err = bef93.NewProc(prog, in, stdout, stderr, procOpts...).Exec()
+	}
+
+	// write the trace and replay log also if the program failed
+	if trace != nil {
+		if terr := trace.Close(); terr != nil && err == nil {
+			return printErr(stderr, mainOpts.trace, terr, mainOpts.plainErrors)
+		}
+	}
+	if record != nil {
+		if rerr := writeReplayLog(mainOpts.record, record); rerr != nil && err == nil {
+			return printErr(stderr, mainOpts.record, rerr, mainOpts.plainErrors)
+		}
+	}
+	if err != nil {
+		return printErr(stderr, srcFile, err, mainOpts.plainErrors)
+	}
+
+	return exitOK
+}
+
+func readReplayLog(fileName string) (*bef93.ReplayLog, error) {
+	// #nosec G304
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	log := &bef93.ReplayLog{}
+	err = json.Unmarshal(b, log)
+	if err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+func writeReplayLog(fileName string, log *bef93.ReplayLog) error {
+	b, err := json.Marshal(log)
 	if err != nil {
-		panic(err)
+		return err
 	}
+
+	return os.WriteFile(fileName, append(b, '\n'), 0o600)
+}
+
+// traceFile is a trace being written to a file.
+type traceFile struct {
+	file   *os.File
+	buf    *bufio.Writer
+	tracer *bef93.Tracer
+}
+
+func createTrace(fileName string) (*traceFile, error) {
+	// #nosec G304
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewWriter(file)
+	return &traceFile{file: file, buf: buf, tracer: bef93.NewTracer(buf)}, nil
+}
+
+// Close flushes and closes the trace file, returning the first error encountered.
+func (t *traceFile) Close() error {
+	err := t.tracer.Err()
+	if ferr := t.buf.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := t.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
 }
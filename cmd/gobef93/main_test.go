@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSrc(t *testing.T, code string) string {
+	fileName := filepath.Join(t.TempDir(), "prog.bf")
+	err := os.WriteFile(fileName, []byte(code), 0o600)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return fileName
+}
+
+func Test_run_ExitCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		exit int
+		out  string
+	}{
+		{"ok", []string{writeSrc(t, `12+.@`)}, exitOK, "3 "},
+		{"precompiled", []string{"-precompiled", writeSrc(t, `12+.@`)}, exitOK, "3 "},
+		{"precompiled runtime error", []string{"-precompiled", writeSrc(t, `1.x@`)}, exitRuntimeErr, "1 "},
+		{"help", []string{"-help"}, exitOK, ""},
+		{"no args", []string{}, exitUsageErr, ""},
+		{"unknown flag", []string{"-unknown", writeSrc(t, `@`)}, exitUsageErr, ""},
+		{"missing file", []string{filepath.Join(t.TempDir(), "missing.bf")}, exitIOErr, ""},
+		{"compile error", []string{writeSrc(t, `"ö"@`)}, exitCompileErr, ""},
+		{"unsupported opts", []string{"-max_steps", "-1", writeSrc(t, `@`)}, exitCompileErr, ""},
+		{"runtime error", []string{writeSrc(t, `1.x@`)}, exitRuntimeErr, "1 "},
+		{"input error", []string{"-terminate_on_io_err", writeSrc(t, `1.&.@`)}, exitIOErr, "1 "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+			exit := run(tt.args, strings.NewReader(""), stdout, stderr)
+			if exit != tt.exit {
+				t.Fatalf("invalid exit code %d, stderr: %s", exit, stderr.String())
+			}
+			if stdout.String() != tt.out {
+				t.Fatal("should be equal")
+			}
+			if tt.exit != exitOK && stderr.Len() == 0 {
+				t.Fatal("expected a diagnostic")
+			}
+		})
+	}
+}
+
+func Test_run_Trace(t *testing.T) {
+	src := writeSrc(t, `1.x`)
+	trace := filepath.Join(t.TempDir(), "trace.jsonl")
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	exit := run([]string{"run", "-trace", trace, src}, strings.NewReader(""), stdout, stderr)
+	if exit != exitRuntimeErr {
+		t.Fatalf("invalid exit code %d, stderr: %s", exit, stderr.String())
+	}
+
+	b, err := os.ReadFile(trace)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expected := `{"step":1,"x":0,"y":0,"op":"1","dir":"right","stack":[1],"stack_len":1}
+{"step":2,"x":1,"y":0,"op":".","dir":"right","stack":[],"stack_len":0,"output":"1 "}
+{"step":3,"x":2,"y":0,"op":"x","dir":"right","stack":[],"stack_len":0,"error":"runtime error at (2, 0): unknown opcode: 'x' (120)"}
+`
+	if string(b) != expected {
+		t.Fatalf("should be equal:\n%s", b)
+	}
+}
+
+func Test_run_RecordReplay(t *testing.T) {
+	src := writeSrc(t, `&.?1.@
+  2
+  .
+  @`)
+	log := filepath.Join(t.TempDir(), "replay.json")
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	exit := run([]string{"run", "-record", log, src}, strings.NewReader("7\n"), stdout, stderr)
+	if exit != exitOK {
+		t.Fatalf("invalid exit code %d, stderr: %s", exit, stderr.String())
+	}
+
+	replayed := &bytes.Buffer{}
+	exit = run([]string{"run", "-replay", log, src}, strings.NewReader(""), replayed, stderr)
+	if exit != exitOK {
+		t.Fatalf("invalid exit code %d, stderr: %s", exit, stderr.String())
+	}
+	if replayed.String() != stdout.String() {
+		t.Fatal("should be equal")
+	}
+
+	// diverges, as the log is exhausted
+	exit = run([]string{"run", "-replay", log, writeSrc(t, `&&@`)}, strings.NewReader("1\n2\n"), &bytes.Buffer{}, stderr)
+	if exit != exitRuntimeErr {
+		t.Fatalf("invalid exit code %d", exit)
+	}
+}
+
+func Test_run_Profile(t *testing.T) {
+	src := writeSrc(t, `55+>1-:v
+   ^   _@`)
+	csvFile := filepath.Join(t.TempDir(), "cells.csv")
+	pprofFile := filepath.Join(t.TempDir(), "cells.pb.gz")
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	exit := run([]string{"profile", "-numeric", "-csv", csvFile, "-pprof", pprofFile, src}, strings.NewReader(""), stdout, stderr)
+	if exit != exitOK {
+		t.Fatalf("invalid exit code %d, stderr: %s", exit, stderr.String())
+	}
+
+	for _, s := range []string{
+		"  0|11122222     ",
+		"  1|   111121    ",
+		"100 instructions executed, 0 characters pushed in string mode",
+		"'-'           10  10.0%",
+	} {
+		if !strings.Contains(stdout.String(), s) {
+			t.Fatalf("should contain %q:\n%s", s, stdout.String())
+		}
+	}
+
+	b, err := os.ReadFile(csvFile)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !strings.HasPrefix(string(b), "x,y,op,count\n0,0,5,1\n1,0,5,1\n2,0,+,1\n3,0,>,10\n") {
+		t.Fatalf("invalid csv:\n%s", b)
+	}
+
+	b, err = os.ReadFile(pprofFile)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// gzip magic
+	if !bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+		t.Fatal("pprof profile should be gzip compressed")
+	}
+}
+
+func Test_run_Cover(t *testing.T) {
+	src := writeSrc(t, `&:0`+"`"+`v
+@.  _"gen",,,@`)
+	dir := t.TempDir()
+	inPos, inNeg := filepath.Join(dir, "pos.txt"), filepath.Join(dir, "neg.txt")
+	profile := filepath.Join(dir, "cover.out")
+	for f, s := range map[string]string{inPos: "5\n", inNeg: "-5\n"} {
+		err := os.WriteFile(f, []byte(s), 0o600)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	exit := run([]string{"cover", "-input", inPos, "-profile", profile, "-min", "50", src}, strings.NewReader(""), stdout, stderr)
+	if exit != exitCoverageErr {
+		t.Fatalf("invalid exit code %d, stderr: %s", exit, stderr.String())
+	}
+	for _, s := range []string{
+		"  1|@.  _\"gen\",,,@",
+		"        ^^^^^^^^^\n",
+		"coverage: 8 of 17 cells (47.1%)",
+	} {
+		if !strings.Contains(stdout.String(), s) {
+			t.Fatalf("should contain %q:\n%s", s, stdout.String())
+		}
+	}
+
+	stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	exit = run([]string{"cover", "-no_run", "-merge", profile, "-merge", profile, src}, strings.NewReader(""), stdout, stderr)
+	if exit != exitOK {
+		t.Fatalf("invalid exit code %d, stderr: %s", exit, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "coverage: 8 of 17 cells (47.1%)") {
+		t.Fatalf("invalid coverage:\n%s", stdout.String())
+	}
+
+	stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	exit = run([]string{"cover", "-input", inNeg, "-merge", profile, "-min", "100", src}, strings.NewReader(""), stdout, stderr)
+	if exit != exitOK {
+		t.Fatalf("invalid exit code %d, stderr: %s", exit, stderr.String())
+	}
+	if strings.Contains(stdout.String(), "^\n") || !strings.Contains(stdout.String(), "coverage: 17 of 17 cells (100.0%)") {
+		t.Fatalf("invalid coverage:\n%s", stdout.String())
+	}
+
+	exit = run([]string{"cover", "-no_run", "-merge", src, src}, strings.NewReader(""), stdout, stderr)
+	if exit != exitIOErr {
+		t.Fatalf("invalid exit code %d", exit)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"jo-m.ch/go/gobef93/pkg/bef93"
+)
+
+// heatColors are ANSI 256 color codes used as background, from cold to hot.
+var heatColors = []int{17, 19, 26, 31, 35, 70, 142, 178, 208, 196}
+
+func runProfile(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gobef93 profile", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	opts := bef93.Opts{}
+	registerOptsFlags(fs, &opts)
+
+	inputFile := fs.String("input", "", "File to read program input from. If empty, stdin is used.")
+	numeric := fs.Bool("numeric", false, "Render the heatmap as a numeric overlay instead of ANSI colors.")
+	cellsCSV := fs.String("csv", "", "File to write the execution counts per cell to, as CSV.")
+	opsCSV := fs.String("ops_csv", "", "File to write the execution counts per instruction to, as CSV.")
+	pprofFile := fs.String("pprof", "", "File to write the execution counts per cell to, in pprof format. Inspect it using 'go tool pprof'.")
+
+	fs.Usage = func() {
+		w := fs.Output()
+
+		fmt.Fprintf(w, "Usage of %s:\n", fs.Name())
+		fmt.Fprintf(w, `Executes a Befunge-93 program file and reports how often each cell and
+each instruction was executed.
+Takes a single positional argument, which is the file to execute.
+Program output is written to stderr, the report to stdout.
+`)
+
+		fs.PrintDefaults()
+	}
+
+	srcFile, code, ok := parseFileArg(fs, args)
+	if !ok {
+		return code
+	}
+
+	prog, err := loadProg(srcFile, opts)
+	if err != nil {
+		return printErr(stderr, srcFile, err, false)
+	}
+
+	in := stdin
+	if *inputFile != "" {
+		file, err := openInput(*inputFile)
+		if err != nil {
+			return printErr(stderr, *inputFile, err, false)
+		}
+		// #nosec G307
+		defer file.Close()
+		in = file
+	}
+
+	prof := bef93.NewProfiler()
+	proc := bef93.NewProc(prog, in, stderr, stderr, bef93.WithObserver(prof))
+	execErr := proc.Exec()
+
+	// report also if the program failed
+	writeHeatmap(stdout, proc.Prog(), prof, *numeric)
+	writeOpsTable(stdout, prof)
+
+	for _, f := range []struct {
+		fileName string
+		write    func(io.Writer) error
+	}{
+		{*cellsCSV, prof.WriteCellsCSV},
+		{*opsCSV, prof.WriteOpsCSV},
+		{*pprofFile, func(w io.Writer) error { return prof.WritePprof(w, srcFile) }},
+	} {
+		if f.fileName == "" {
+			continue
+		}
+		err = writeFile(f.fileName, f.write)
+		if err != nil {
+			return printErr(stderr, f.fileName, err, false)
+		}
+	}
+
+	if execErr != nil {
+		return printErr(stderr, srcFile, execErr, false)
+	}
+
+	return exitOK
+}
+
+// writeFile creates a file and writes to it using write.
+func writeFile(fileName string, write func(io.Writer) error) error {
+	// #nosec G304
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	err = write(file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// heatLevel returns the level of count in [0, levels), on a log scale up to maxCount.
+func heatLevel(count, maxCount int64, levels int) int {
+	level := int(math.Log(float64(count)) / math.Log(float64(maxCount)+1) * float64(levels))
+	return min(level, levels-1)
+}
+
+// writeHeatmap renders the execution counts over the Prog.String() layout.
+func writeHeatmap(w io.Writer, prog *bef93.Prog, prof *bef93.Profiler, numeric bool) {
+	_, h := prog.Size()
+	maxCount := prof.MaxCellCount()
+
+	lines := strings.Split(prog.String(), "\n")
+	for i, l := range lines {
+		// first two lines are the top numbering and border
+		y := i - 2
+		if y < 0 || y >= h {
+			fmt.Fprintln(w, l)
+			continue
+		}
+
+		start, end := strings.IndexRune(l, '|'), strings.LastIndex(l, "|")
+		b := strings.Builder{}
+		b.WriteString(l[:start+1])
+		for x, r := range []rune(l[start+1 : end]) {
+			count := prof.CellCount(x, y)
+			if r < ' ' {
+				r = ' '
+			}
+
+			switch {
+			case numeric && count == 0:
+				b.WriteByte(' ')
+			case numeric:
+				// number of decimal digits
+				b.WriteString(strconv.Itoa(min(len(strconv.FormatInt(count, 10)), 9)))
+			case count == 0:
+				b.WriteRune(r)
+			default:
+				fmt.Fprintf(&b, "\x1b[30;48;5;%dm%c%s", heatColors[heatLevel(count, maxCount, len(heatColors))], r, ansiReset)
+			}
+		}
+		b.WriteString(l[end:])
+		fmt.Fprintln(w, b.String())
+	}
+
+	if numeric {
+		fmt.Fprintf(w, "\ncells show the number of digits of their execution count, maximum %d\n", maxCount)
+		return
+	}
+
+	fmt.Fprint(w, "\nexecution count (log scale): 1 ")
+	for _, c := range heatColors {
+		fmt.Fprintf(w, "\x1b[48;5;%dm %s", c, ansiReset)
+	}
+	fmt.Fprintf(w, " %d\n", maxCount)
+}
+
+// writeOpsTable writes the execution counts per instruction.
+func writeOpsTable(w io.Writer, prof *bef93.Profiler) {
+	total := prof.Total()
+	fmt.Fprintf(w, "\n%d instructions executed, %d characters pushed in string mode\n", total, prof.StrModeCount())
+
+	fmt.Fprintln(w, "op         count      %")
+	for _, o := range prof.Ops() {
+		fmt.Fprintf(w, "%-4s %11d %5.1f%%\n", strconv.QuoteRune(o.Op), o.Count, float64(o.Count)/float64(total)*100)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func ioctlTermios(fd uintptr, req uintptr, t *syscall.Termios) error {
+	// #nosec G103
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// makeRaw disables line buffering and echo on the terminal fd,
+// so that single key presses can be read.
+// Signals are disabled as well, so that Ctrl-C is read as a key (keyCtrlC)
+// and the terminal can be restored before exiting.
+// Returns a function restoring the previous terminal state.
+func makeRaw(fd uintptr) (func(), error) {
+	old := syscall.Termios{}
+	err := ioctlTermios(fd, syscall.TCGETS, &old)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := old
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO | syscall.ISIG
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	err = ioctlTermios(fd, syscall.TCSETS, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { _ = ioctlTermios(fd, syscall.TCSETS, &old) }, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// makeRaw is not supported on this platform,
+// key presses need to be confirmed with enter.
+func makeRaw(uintptr) (func(), error) {
+	return nil, errors.New("raw terminal mode not supported")
+}
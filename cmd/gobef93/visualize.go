@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"jo-m.ch/go/gobef93/pkg/bef93"
+)
+
+// ANSI escape sequences
+const (
+	ansiReset      = "\x1b[0m"
+	ansiReverse    = "\x1b[7m"
+	ansiYellow     = "\x1b[33m"
+	ansiClear      = "\x1b[H\x1b[2J"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+)
+
+// keyCtrlC is read instead of SIGINT being sent in raw terminal mode, see makeRaw().
+const keyCtrlC = 0x03
+
+// visualizer panel sizes
+const (
+	visStackSize  = 10
+	visOutputRows = 5
+	visMinDelay   = time.Millisecond
+	visMaxDelay   = 2 * time.Second
+)
+
+const visualizeKeys = "keys: space pause/resume, s step, + faster, - slower, q or Ctrl-C quit"
+
+// visualizer renders a running program to a terminal, step by step.
+type visualizer struct {
+	bef93.NopObserver
+
+	proc    *bef93.Proc
+	term    io.Writer
+	output  *bytes.Buffer    // program output
+	changed map[[2]int]bool  // cells changed by 'p'
+	delay   time.Duration    // delay per step
+	paused  bool             // if true, only step on key press
+	status  string           // last status message
+	keys    <-chan byte      // key presses
+	err     error            // error which terminated the program
+	frame   *strings.Builder // frame buffer
+}
+
+// OnPut implements bef93.Observer.
+func (v *visualizer) OnPut(x, y int, _, _ rune) {
+	v.changed[[2]int{x, y}] = true
+}
+
+// readKeys sends bytes read from r to the returned channel.
+func readKeys(r io.Reader) <-chan byte {
+	keys := make(chan byte)
+
+	go func() {
+		defer close(keys)
+
+		br := bufio.NewReader(r)
+		for {
+			b, err := br.ReadByte()
+			if err != nil {
+				return
+			}
+			keys <- b
+		}
+	}()
+
+	return keys
+}
+
+// runVisualized executes prog, rendering each step to term.
+// Key presses are read from keys.
+func runVisualized(prog *bef93.Prog, in, keys io.Reader, term io.Writer, delay time.Duration, opts ...bef93.ProcOption) error {
+	v := &visualizer{
+		term:    term,
+		output:  &bytes.Buffer{},
+		changed: map[[2]int]bool{},
+		delay:   delay,
+		keys:    readKeys(keys),
+		frame:   &strings.Builder{},
+	}
+	v.proc = bef93.NewProc(prog, in, v.output, v.output, append(opts, bef93.WithObserver(v))...)
+
+	fmt.Fprint(term, ansiHideCursor)
+	defer fmt.Fprint(term, ansiShowCursor)
+
+	for !v.proc.State().Done {
+		v.render()
+
+		if !v.waitStep() {
+			v.status = "quit"
+			break
+		}
+
+		_, v.err = v.proc.Step()
+	}
+
+	v.render()
+	return v.err
+}
+
+// waitStep waits until the next step should be executed, handling key presses.
+// Returns false if the user wants to quit.
+func (v *visualizer) waitStep() bool {
+	for {
+		var timeout <-chan time.Time
+		if !v.paused {
+			timeout = time.After(v.delay)
+		}
+
+		select {
+		case key, ok := <-v.keys:
+			if !ok {
+				// no more keys, keep running
+				v.keys = nil
+				continue
+			}
+
+			switch key {
+			case ' ':
+				v.paused = !v.paused
+				v.render()
+			case 's', 'n':
+				if v.paused {
+					return true
+				}
+			case '+':
+				v.delay = max(v.delay/2, visMinDelay)
+				v.render()
+			case '-':
+				v.delay = min(v.delay*2, visMaxDelay)
+				v.render()
+			case 'q', keyCtrlC:
+				return false
+			}
+		case <-timeout:
+			return true
+		}
+	}
+}
+
+// render draws the grid, stack, output and status to the terminal.
+func (v *visualizer) render() {
+	state := v.proc.State()
+	prog := v.proc.Prog()
+	w, h := prog.Size()
+	b := v.frame
+	b.Reset()
+
+	b.WriteString(ansiClear)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r := prog.Cell(x, y)
+			if r < ' ' {
+				r = ' '
+			}
+
+			switch {
+			case x == state.PCX && y == state.PCY && !state.Done:
+				fmt.Fprintf(b, "%s%c%s", ansiReverse, r, ansiReset)
+			case v.changed[[2]int{x, y}]:
+				fmt.Fprintf(b, "%s%c%s", ansiYellow, r, ansiReset)
+			default:
+				b.WriteRune(r)
+			}
+		}
+		b.WriteByte('\n')
+	}
+
+	fmt.Fprintf(b, "\nstack (%d values, top first):", len(state.Stack))
+	for i := len(state.Stack) - 1; i >= 0 && i >= len(state.Stack)-visStackSize; i-- {
+		fmt.Fprintf(b, " %d", state.Stack[i])
+	}
+	b.WriteString("\n\noutput:\n")
+
+	lines := strings.Split(v.output.String(), "\n")
+	if len(lines) > visOutputRows {
+		lines = lines[len(lines)-visOutputRows:]
+	}
+	for _, l := range lines {
+		b.WriteString(l + "\n")
+	}
+
+	fmt.Fprintf(b, "\nstep %d, pc (%d, %d) %s, delay %s", state.Steps, state.PCX, state.PCY, state.Dir, v.delay)
+	switch {
+	case v.err != nil:
+		fmt.Fprintf(b, ", error: %s", v.err)
+	case state.Done:
+		b.WriteString(", terminated")
+	case v.paused:
+		b.WriteString(", paused")
+	}
+	if v.status != "" {
+		fmt.Fprintf(b, ", %s", v.status)
+	}
+	fmt.Fprintf(b, "\n%s\n", visualizeKeys)
+
+	fmt.Fprint(v.term, b.String())
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_runVisualize(t *testing.T) {
+	src := writeSrc(t, `"a",55+,v
+        @`)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	exit := run([]string{"run", "-visualize", "-delay", "1ms", src}, strings.NewReader(""), stdout, stderr)
+	if exit != exitOK {
+		t.Fatalf("invalid exit code %d, stderr: %s", exit, stderr.String())
+	}
+
+	frames := strings.Split(stdout.String(), ansiClear)
+	last := frames[len(frames)-1]
+
+	if !strings.Contains(last, "output:\na\n") {
+		t.Fatalf("should contain output:\n%s", last)
+	}
+	if !strings.Contains(last, "step 10, pc (8, 1) down, delay 1ms, terminated") {
+		t.Fatalf("should be terminated:\n%s", last)
+	}
+}
+
+func Test_runVisualize_Keys(t *testing.T) {
+	src := writeSrc(t, `1 2 3 @`)
+
+	// pause, step twice, quit
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	exit := run([]string{"run", "-visualize", "-delay", "1h", src}, strings.NewReader(" ssq"), stdout, stderr)
+	if exit != exitOK {
+		t.Fatalf("invalid exit code %d, stderr: %s", exit, stderr.String())
+	}
+
+	frames := strings.Split(stdout.String(), ansiClear)
+	last := frames[len(frames)-1]
+
+	if !strings.Contains(last, "step 2, pc (2, 0) right, delay 1h0m0s, paused, quit") {
+		t.Fatalf("should be paused:\n%s", last)
+	}
+}
+
+func Test_runVisualize_CtrlC(t *testing.T) {
+	src := writeSrc(t, `1 2 3 @`)
+
+	// pause, Ctrl-C
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	exit := run([]string{"run", "-visualize", "-delay", "1h", src}, strings.NewReader(" \x03"), stdout, stderr)
+	if exit != exitOK {
+		t.Fatalf("invalid exit code %d, stderr: %s", exit, stderr.String())
+	}
+
+	frames := strings.Split(stdout.String(), ansiClear)
+	last := frames[len(frames)-1]
+
+	if !strings.Contains(last, "step 0, pc (0, 0) right, delay 1h0m0s, paused, quit") {
+		t.Fatalf("should be quit:\n%s", last)
+	}
+	if !strings.HasSuffix(stdout.String(), ansiShowCursor) {
+		t.Fatal("should show the cursor")
+	}
+}
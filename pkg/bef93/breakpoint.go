@@ -0,0 +1,134 @@
+package bef93
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrBreakpoint is returned by Exec() when a breakpoint is hit.
+// Will be wrapped in a RuntimeError, so use errors.Is/As().
+// Unlike other errors, it does not terminate the proc,
+// execution can be resumed by calling Exec() again.
+var ErrBreakpoint = errors.New("breakpoint")
+
+type breakpointKind uint8
+
+const (
+	bpCell breakpointKind = iota
+	bpOp
+	bpCond
+	bpWatch
+)
+
+// Breakpoint suspends execution when hit, see Proc.AddBreakpoint().
+// Construct using CellBreakpoint(), OpBreakpoint(), CondBreakpoint() or Watchpoint().
+type Breakpoint struct {
+	kind           breakpointKind
+	x0, y0, x1, y1 int
+	op             rune
+	cond           func(ProcState) bool
+}
+
+// CellBreakpoint returns a breakpoint which is hit before the instruction at (x, y) is executed.
+func CellBreakpoint(x, y int) *Breakpoint {
+	return &Breakpoint{kind: bpCell, x0: x, y0: y, x1: x, y1: y}
+}
+
+// OpBreakpoint returns a breakpoint which is hit before op is executed.
+// It is not hit for cells pushed in string mode.
+func OpBreakpoint(op rune) *Breakpoint {
+	return &Breakpoint{kind: bpOp, op: op}
+}
+
+// CondBreakpoint returns a breakpoint which is hit before an instruction is executed
+// if cond returns true. The state passed to cond is only valid for the duration of the call,
+// its Stack must not be modified or retained.
+func CondBreakpoint(cond func(ProcState) bool) *Breakpoint {
+	return &Breakpoint{kind: bpCond, cond: cond}
+}
+
+// Watchpoint returns a breakpoint which is hit after a 'p' operation wrote to a cell
+// inside the rectangle from (x0, y0) to (x1, y1), inclusive.
+func Watchpoint(x0, y0, x1, y1 int) *Breakpoint {
+	return &Breakpoint{kind: bpWatch, x0: min(x0, x1), y0: min(y0, y1), x1: max(x0, x1), y1: max(y0, y1)}
+}
+
+func (bp *Breakpoint) String() string {
+	switch bp.kind {
+	case bpCell:
+		return fmt.Sprintf("cell (%d, %d)", bp.x0, bp.y0)
+	case bpOp:
+		return fmt.Sprintf("op '%c'", bp.op)
+	case bpCond:
+		return "condition"
+	default:
+		return fmt.Sprintf("watch (%d, %d)-(%d, %d)", bp.x0, bp.y0, bp.x1, bp.y1)
+	}
+}
+
+func (bp *Breakpoint) contains(x, y int) bool {
+	return x >= bp.x0 && x <= bp.x1 && y >= bp.y0 && y <= bp.y1
+}
+
+// AddBreakpoint adds a breakpoint to the proc.
+// Breakpoints are only checked by Exec() and ExecContext(), not by Step().
+// When resuming execution after a breakpoint was hit or after StepBack(),
+// the breakpoints at the current location are skipped once.
+func (p *Proc) AddBreakpoint(bp *Breakpoint) {
+	p.breakpoints = append(p.breakpoints, bp)
+}
+
+// RemoveBreakpoint removes a breakpoint previously added by AddBreakpoint().
+func (p *Proc) RemoveBreakpoint(bp *Breakpoint) {
+	for i, b := range p.breakpoints {
+		if b == bp {
+			p.breakpoints = append(p.breakpoints[:i], p.breakpoints[i+1:]...)
+			return
+		}
+	}
+}
+
+// Breakpoints returns the breakpoints of the proc.
+func (p *Proc) Breakpoints() []*Breakpoint {
+	ret := make([]*Breakpoint, len(p.breakpoints))
+	copy(ret, p.breakpoints)
+	return ret
+}
+
+// checkBreakpoints returns an error if a breakpoint is hit at the current location.
+func (p *Proc) checkBreakpoints() error {
+	if p.resumeSteps == p.steps+1 {
+		// resuming from this location
+		return nil
+	}
+
+	op := p.currentOp()
+	for _, bp := range p.breakpoints {
+		hit := false
+		switch bp.kind {
+		case bpCell:
+			hit = bp.contains(p.pcX, p.pcY)
+		case bpOp:
+			hit = rune(op) == bp.op && (!p.strMode || op == opStr)
+		case bpCond:
+			hit = bp.cond(p.stateView())
+		}
+
+		if hit {
+			p.resumeSteps = p.steps + 1
+			return p.newRuntimeError(fmt.Errorf("%w: %s", ErrBreakpoint, bp))
+		}
+	}
+
+	return nil
+}
+
+// checkWatchpoints records a hit watchpoint when a 'p' operation writes to (x, y).
+func (p *Proc) checkWatchpoints(x, y int) {
+	for _, bp := range p.breakpoints {
+		if bp.kind == bpWatch && bp.contains(x, y) {
+			p.watchHit = fmt.Errorf("%w: %s: write to (%d, %d)", ErrBreakpoint, bp, x, y)
+			return
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package bef93
+
+import (
+	"errors"
+	"testing"
+)
+
+func execUntilBreak(t *testing.T, proc *Proc) *RuntimeError {
+	err := proc.Exec()
+
+	var rerr *RuntimeError
+	if !errors.Is(err, ErrBreakpoint) || !errors.As(err, &rerr) {
+		t.Fatalf("expected ErrBreakpoint, got %v", err)
+	}
+
+	return rerr
+}
+
+func Test_Breakpoint_Cell(t *testing.T) {
+	proc, _, stdout, _ := createProc(t, `1.2.3.@`, Opts{})
+	bp := CellBreakpoint(2, 0)
+	proc.AddBreakpoint(bp)
+
+	rerr := execUntilBreak(t, proc)
+	if rerr.LocX != 2 || rerr.LocY != 0 {
+		t.Fatal("invalid location")
+	}
+	if stdout.String() != "1 " {
+		t.Fatal("should be equal")
+	}
+
+	proc.RemoveBreakpoint(bp)
+	proc.AddBreakpoint(CellBreakpoint(4, 0))
+
+	rerr = execUntilBreak(t, proc)
+	if rerr.LocX != 4 || rerr.LocY != 0 {
+		t.Fatal("invalid location")
+	}
+
+	err := proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if stdout.String() != "1 2 3 " {
+		t.Fatal("should be equal")
+	}
+}
+
+func Test_Breakpoint_CellLoop(t *testing.T) {
+	proc, _, _, _ := createProc(t, `0>1+:3-v
+ ^     _@`, Opts{})
+	proc.AddBreakpoint(CellBreakpoint(2, 0))
+
+	for i := 0; i < 3; i++ {
+		execUntilBreak(t, proc)
+		if len(proc.State().Stack) != 1 || proc.State().Stack[0] != int64(i) {
+			t.Fatalf("invalid stack %v", proc.State().Stack)
+		}
+	}
+
+	err := proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func Test_Breakpoint_Op(t *testing.T) {
+	proc, _, _, _ := createProc(t, `"p"1 1p1 2p@`, Opts{})
+	proc.AddBreakpoint(OpBreakpoint('p'))
+
+	rerr := execUntilBreak(t, proc)
+	if rerr.LocX != 6 {
+		t.Fatal("invalid location")
+	}
+
+	rerr = execUntilBreak(t, proc)
+	if rerr.LocX != 10 {
+		t.Fatal("invalid location")
+	}
+
+	err := proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func Test_Breakpoint_Cond(t *testing.T) {
+	proc, _, _, _ := createProc(t, `>1v
+^ <`, Opts{})
+	proc.AddBreakpoint(CondBreakpoint(func(state ProcState) bool {
+		return len(state.Stack) > 10
+	}))
+
+	execUntilBreak(t, proc)
+	if len(proc.State().Stack) != 11 {
+		t.Fatal("invalid stack size")
+	}
+}
+
+func Test_Breakpoint_Watch(t *testing.T) {
+	proc, _, _, _ := createProc(t, `"a"00p"b"55p"c"99p@`, Opts{})
+	proc.AddBreakpoint(Watchpoint(5, 5, 1, 1))
+
+	rerr := execUntilBreak(t, proc)
+	if rerr.LocX != 12 || rerr.LocY != 0 {
+		t.Fatal("invalid location")
+	}
+	if rerr.Msg != "breakpoint: watch (1, 1)-(5, 5): write to (5, 5)" {
+		t.Fatalf("invalid message: %s", rerr.Msg)
+	}
+
+	err := proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func Test_Breakpoint_Step(t *testing.T) {
+	proc, _, _, _ := createProc(t, `123@`, Opts{})
+	proc.AddBreakpoint(CellBreakpoint(1, 0))
+	proc.AddBreakpoint(CellBreakpoint(2, 0))
+
+	execUntilBreak(t, proc)
+
+	_, err := proc.Step()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rerr := execUntilBreak(t, proc)
+	if rerr.LocX != 2 {
+		t.Fatal("invalid location")
+	}
+}
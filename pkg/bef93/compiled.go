@@ -0,0 +1,143 @@
+package bef93
+
+// compiledCell is a predecoded cell of the program grid.
+type compiledCell struct {
+	op   opcode
+	exec opHandler
+}
+
+// compiledProg is a dense table of predecoded cells, indexed by y*w+x.
+// Handlers are looked up once per cell, instead of for every instruction executed.
+type compiledProg struct {
+	cells []compiledCell
+	w     int
+
+	// wrapped coordinates, indexed by coordinate+2,
+	// replacing the modulo operations of Proc.advancePC()
+	wrapX, wrapY []int
+}
+
+// WithPrecompiled makes the proc use an alternative execution engine, which
+// predecodes the program grid into a table of handlers per cell.
+// Cells modified by 'p' are decoded again.
+// Results are the same as with the default engine, but execution is faster.
+// Unless breakpoints are set, ExecContext() checks the context only every
+// 1024 instructions, blocking reads are still interrupted immediately.
+func WithPrecompiled() ProcOption {
+	return func(p *Proc) {
+		p.compiled = compileProg(&p.prog)
+	}
+}
+
+func compileProg(prog *Prog) *compiledProg {
+	c := &compiledProg{
+		cells: make([]compiledCell, prog.w*prog.h),
+		w:     prog.w,
+		wrapX: wrapTable(prog.w),
+		wrapY: wrapTable(prog.h),
+	}
+	for y := 0; y < prog.h; y++ {
+		for x := 0; x < prog.w; x++ {
+			c.update(prog, x, y)
+		}
+	}
+	return c
+}
+
+// wrapTable maps the coordinates -2 to n+1 to the coordinates wrapped like in
+// Proc.advancePC(). The PC moves at most two cells from inside the grid
+// before it is wrapped (see opSkip).
+func wrapTable(n int) []int {
+	ret := make([]int, n+4)
+	for i := range ret {
+		if c := i - 2; c < 0 {
+			ret[i] = n - 1
+		} else {
+			ret[i] = c % n
+		}
+	}
+	return ret
+}
+
+// advancePC is like Proc.advancePC(), but faster.
+func (c *compiledProg) advancePC(p *Proc) {
+	p.movePC()
+	p.pcX = c.wrapX[p.pcX+2]
+	p.pcY = c.wrapY[p.pcY+2]
+}
+
+// update decodes the cell at (x, y) again, after it was modified.
+func (c *compiledProg) update(prog *Prog, x, y int) {
+	op := opcode(prog.code[y][x])
+	c.cells[y*c.w+x] = compiledCell{op: op, exec: lookupOp(op)}
+}
+
+// compiledBatchSteps is the number of instructions executed by
+// ExecContext() using compiledProg.run() between checking the context.
+const compiledBatchSteps = 1024
+
+// run executes up to n instructions, like calling Proc.Step() n times.
+// Breakpoints are not checked.
+func (c *compiledProg) run(p *Proc, n int) (bool, error) {
+	n, err := p.stepBudget(n)
+	if err != nil {
+		return true, err
+	}
+	if p.journal == nil && p.observers == nil {
+		return c.runFast(p, n)
+	}
+
+	for ; n > 0; n-- {
+		p.beginStep()
+
+		cell := &c.cells[p.pcY*c.w+p.pcX]
+		// the handler might modify the cell
+		op := cell.op
+
+		var err error
+		if p.strMode && op != opStr {
+			p.stack.push(int64(op))
+		} else {
+			err = cell.exec(p, op)
+		}
+		if err == nil {
+			err = p.checkStackDepth()
+		}
+		if err == nil {
+			c.advancePC(p)
+		}
+
+		if err := p.endStep(op, err); err != nil || p.done {
+			return p.done, err
+		}
+	}
+	return false, nil
+}
+
+// runFast is run() for procs without a journal and observers,
+// which skips their bookkeeping for each instruction.
+func (c *compiledProg) runFast(p *Proc, n int) (bool, error) {
+	maxDepth := p.prog.opts.MaxStackDepth
+
+	for ; n > 0; n-- {
+		p.steps++
+
+		cell := &c.cells[p.pcY*c.w+p.pcX]
+		// the handler might modify the cell
+		op := cell.op
+
+		if p.strMode && op != opStr {
+			p.stack.push(int64(op))
+		} else if err := cell.exec(p, op); err != nil {
+			err = p.endStep(op, err)
+			return p.done, err
+		}
+		if maxDepth > 0 && p.stack.sp > maxDepth {
+			err := p.endStep(op, p.stackOverflowError())
+			return p.done, err
+		}
+
+		c.advancePC(p)
+	}
+	return false, nil
+}
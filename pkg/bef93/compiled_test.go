@@ -0,0 +1,124 @@
+package bef93
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func Test_Precompiled_Ignore(t *testing.T) {
+	for _, opts := range []Opts{{}, {IgnoreUnsupportedInstructions: true}} {
+		prog, err := NewProg(`1x.@`, opts)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		out := &bytes.Buffer{}
+		err = NewProc(prog, strings.NewReader(""), out, io.Discard, WithPrecompiled()).Exec()
+		if opts.IgnoreUnsupportedInstructions {
+			if err != nil || out.String() != "1 " {
+				t.Fatal("should be equal")
+			}
+		} else if !errors.Is(err, ErrUnknownOpCode) {
+			t.Fatalf("expected ErrUnknownOpCode, got %v", err)
+		}
+	}
+}
+
+func Test_Precompiled_StepBack(t *testing.T) {
+	// prints the '0' at (0, 0), then replaces it with '9'
+	prog, err := NewProg(`0."9"00p`, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	out := &bytes.Buffer{}
+	proc := NewProc(prog, strings.NewReader(""), out, io.Discard, WithPrecompiled(), WithJournal(100))
+	_, err = proc.StepN(8)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	err = proc.RunBackTo(0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// the restored '0' is executed again
+	_, err = proc.StepN(2)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if out.String() != "0 0 " {
+		t.Fatalf("invalid output %q", out.String())
+	}
+}
+
+func Test_Precompiled_Snapshot(t *testing.T) {
+	prog, err := NewProg(`"9"60p0.@`, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	proc := NewProc(prog, strings.NewReader(""), io.Discard, io.Discard)
+	_, err = proc.StepN(6)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	snap, err := proc.MarshalBinary()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// the table is created before the snapshot is restored
+	empty, err := NewProg(``, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	out := &bytes.Buffer{}
+	restored := NewProc(empty, strings.NewReader(""), out, io.Discard, WithPrecompiled())
+	err = restored.UnmarshalBinary(snap)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = restored.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if out.String() != "9 " {
+		t.Fatalf("invalid output %q", out.String())
+	}
+}
+
+// counts down from 100*100, storing the counter at (0, 2) using 'p' in each iteration
+const benchCode = `"d":*  >1-: v
+       ^p20:_@`
+
+func benchmarkExec(b *testing.B, opts ...ProcOption) {
+	prog, err := NewProg(benchCode, Opts{})
+	if err != nil {
+		b.Fatalf(err.Error())
+	}
+
+	steps := int64(0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		proc := NewProc(prog, strings.NewReader(""), io.Discard, io.Discard, opts...)
+		err := proc.Exec()
+		if err != nil {
+			b.Fatalf(err.Error())
+		}
+		steps += proc.Steps()
+	}
+	b.ReportMetric(float64(steps)/b.Elapsed().Seconds(), "steps/s")
+}
+
+func Benchmark_Exec(b *testing.B) {
+	benchmarkExec(b)
+}
+
+func Benchmark_Exec_Precompiled(b *testing.B) {
+	benchmarkExec(b, WithPrecompiled())
+}
@@ -0,0 +1,210 @@
+package bef93
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by Coverage.Merge() and ReadCoverageProfile().
+var (
+	ErrCoverageMismatch = errors.New("coverage of different programs")
+	ErrInvalidProfile   = errors.New("invalid coverage profile")
+)
+
+// header of the coverage profile format
+const coverProfileMode = "mode: count"
+
+// DefaultMaxProfileCells is the limit used by ReadCoverageProfile() if maxCells is 0.
+const DefaultMaxProfileCells = 1 << 24
+
+// Coverage records which cells of a program were executed.
+// Only cells which are not spaces in the original program are coverable.
+// Attach it to one or more Procs using WithObserver(), and merge coverage
+// of different runs using Merge().
+// Unlike Profiler, it also counts instructions which fail with an error.
+type Coverage struct {
+	NopObserver
+
+	w, h   int
+	ops    [][]rune  // original program, indexed by y, x
+	counts [][]int64 // execution counts, indexed by y, x
+}
+
+// NewCoverage creates an empty Coverage for prog.
+func NewCoverage(prog *Prog) *Coverage {
+	c := newCoverage(prog.w, prog.h)
+	for y, l := range prog.code {
+		copy(c.ops[y], l)
+	}
+	return c
+}
+
+func newCoverage(w, h int) *Coverage {
+	c := &Coverage{
+		w:      w,
+		h:      h,
+		ops:    make([][]rune, h),
+		counts: make([][]int64, h),
+	}
+	for y := range c.ops {
+		c.ops[y] = []rune(strings.Repeat(" ", w))
+		c.counts[y] = make([]int64, w)
+	}
+	return c
+}
+
+// BeforeStep implements Observer.
+func (c *Coverage) BeforeStep(state ProcState) {
+	if state.PCY < c.h && state.PCX < c.w {
+		c.counts[state.PCY][state.PCX]++
+	}
+}
+
+// Coverable returns true if the cell at (x, y) is not a space in the original program.
+func (c *Coverage) Coverable(x, y int) bool {
+	return x >= 0 && x < c.w && y >= 0 && y < c.h && c.ops[y][x] != ' '
+}
+
+// Count returns how often the cell at (x, y) was executed.
+func (c *Coverage) Count(x, y int) int64 {
+	if x < 0 || x >= c.w || y < 0 || y >= c.h {
+		return 0
+	}
+	return c.counts[y][x]
+}
+
+// Covered returns the number of coverable cells which were executed,
+// and the number of coverable cells.
+func (c *Coverage) Covered() (covered, coverable int) {
+	for y, l := range c.ops {
+		for x, op := range l {
+			if op == ' ' {
+				continue
+			}
+			coverable++
+			if c.counts[y][x] > 0 {
+				covered++
+			}
+		}
+	}
+	return
+}
+
+// Percent returns the percentage of coverable cells which were executed.
+// Returns 100 if there are no coverable cells.
+func (c *Coverage) Percent() float64 {
+	covered, coverable := c.Covered()
+	if coverable == 0 {
+		return 100
+	}
+	return float64(covered) / float64(coverable) * 100
+}
+
+// Merge adds the counts of other, which must be the coverage of the same program.
+func (c *Coverage) Merge(other *Coverage) error {
+	if c.w != other.w || c.h != other.h {
+		return fmt.Errorf("%w: size %dx%d and %dx%d", ErrCoverageMismatch, c.w, c.h, other.w, other.h)
+	}
+	for y, l := range c.ops {
+		for x, op := range l {
+			if op != other.ops[y][x] {
+				return fmt.Errorf("%w: cell (%d, %d) differs", ErrCoverageMismatch, x, y)
+			}
+		}
+	}
+
+	for y, l := range other.counts {
+		for x, n := range l {
+			c.counts[y][x] += n
+		}
+	}
+	return nil
+}
+
+// WriteProfile writes the coverage in a line based text format:
+// A "mode: count" line, a "size: <w>x<h>" line, and a line
+// "<x>,<y> <op> <count>" for each coverable cell, where op is a Go quoted rune.
+// Read it using ReadCoverageProfile().
+func (c *Coverage) WriteProfile(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "%s\nsize: %dx%d\n", coverProfileMode, c.w, c.h)
+	for y, l := range c.ops {
+		for x, op := range l {
+			if op != ' ' {
+				fmt.Fprintf(bw, "%d,%d %s %d\n", x, y, strconv.QuoteRune(op), c.counts[y][x])
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ReadCoverageProfile reads a coverage profile written by Coverage.WriteProfile().
+// Profiles of programs with more than maxCells cells (width * height) are
+// rejected before the coverage is allocated. If maxCells is 0, DefaultMaxProfileCells is used.
+func ReadCoverageProfile(r io.Reader, maxCells int) (*Coverage, error) {
+	scanner := bufio.NewScanner(r)
+	lineNr := 0
+	next := func() (string, bool) {
+		lineNr++
+		if !scanner.Scan() {
+			return "", false
+		}
+		return scanner.Text(), true
+	}
+	invalid := func(msg string) error {
+		return fmt.Errorf("%w: line %d: %s", ErrInvalidProfile, lineNr, msg)
+	}
+
+	if l, ok := next(); !ok || l != coverProfileMode {
+		return nil, invalid("expected '" + coverProfileMode + "'")
+	}
+
+	var w, h int
+	l, _ := next()
+	if n, err := fmt.Sscanf(l, "size: %dx%d", &w, &h); n != 2 || err != nil || w <= 0 || h <= 0 {
+		return nil, invalid("expected 'size: <w>x<h>'")
+	}
+	if maxCells <= 0 {
+		maxCells = DefaultMaxProfileCells
+	}
+	if w > maxCells || h > maxCells/w {
+		return nil, invalid(fmt.Sprintf("size %dx%d exceeds the limit of %d cells", w, h, maxCells))
+	}
+	c := newCoverage(w, h)
+
+	for l, ok := next(); ok; l, ok = next() {
+		fields := strings.Fields(l)
+		if len(fields) != 3 {
+			return nil, invalid("expected '<x>,<y> <op> <count>'")
+		}
+
+		var x, y int
+		if n, err := fmt.Sscanf(fields[0], "%d,%d", &x, &y); n != 2 || err != nil || x < 0 || x >= w || y < 0 || y >= h {
+			return nil, invalid("invalid location")
+		}
+
+		op, err := strconv.Unquote(fields[1])
+		if err != nil || fields[1][0] != '\'' || op == " " {
+			return nil, invalid("invalid op")
+		}
+
+		count, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil || count < 0 {
+			return nil, invalid("invalid count")
+		}
+
+		c.ops[y][x] = []rune(op)[0]
+		c.counts[y][x] = count
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
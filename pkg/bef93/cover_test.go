@@ -0,0 +1,116 @@
+package bef93
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// prints the input number if positive, "neg" otherwise
+const coverCode = `&:0` + "`" + `v
+@.  _"gen",,,@`
+
+func coverRun(t *testing.T, input string) *Coverage {
+	prog, err := NewProg(coverCode, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	cov := NewCoverage(prog)
+	err = NewProc(prog, strings.NewReader(input), &bytes.Buffer{}, &bytes.Buffer{}, WithObserver(cov)).Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return cov
+}
+
+func Test_Coverage(t *testing.T) {
+	cov := coverRun(t, "5\n")
+
+	covered, coverable := cov.Covered()
+	if covered != 8 || coverable != 17 {
+		t.Fatalf("invalid coverage %d of %d", covered, coverable)
+	}
+	if cov.Coverable(2, 1) || !cov.Coverable(0, 1) || cov.Coverable(-1, 0) {
+		t.Fatal("invalid coverable")
+	}
+	if cov.Count(0, 1) != 1 || cov.Count(7, 1) != 0 || cov.Count(100, 0) != 0 {
+		t.Fatal("invalid count")
+	}
+
+	err := cov.Merge(coverRun(t, "-5\n"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	covered, _ = cov.Covered()
+	if covered != 17 || cov.Percent() != 100 || cov.Count(0, 0) != 2 {
+		t.Fatalf("invalid coverage %d", covered)
+	}
+}
+
+func Test_Coverage_Merge_Mismatch(t *testing.T) {
+	for _, code := range []string{`@`, coverCode + "\n\n" + strings.Repeat(" ", 100)} {
+		prog, err := NewProg(code, Opts{AllowArbitraryCodeSize: true})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		err = coverRun(t, "1\n").Merge(NewCoverage(prog))
+		if !errors.Is(err, ErrCoverageMismatch) {
+			t.Fatalf("expected ErrCoverageMismatch, got %v", err)
+		}
+	}
+}
+
+func Test_Coverage_Profile(t *testing.T) {
+	cov := coverRun(t, "5\n")
+
+	b := &bytes.Buffer{}
+	err := cov.WriteProfile(b)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !strings.HasPrefix(b.String(), "mode: count\nsize: 80x25\n0,0 '&' 1\n1,0 ':' 1\n2,0 '0' 1\n3,0 '`' 1\n4,0 'v' 1\n") {
+		t.Fatalf("invalid profile:\n%s", b.String())
+	}
+
+	parsed, err := ReadCoverageProfile(b, Width*Height)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if parsed.Percent() != cov.Percent() {
+		t.Fatal("should be equal")
+	}
+	err = parsed.Merge(coverRun(t, "-5\n"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if parsed.Percent() != 100 {
+		t.Fatal("should be equal")
+	}
+
+	for _, profile := range []string{
+		"",
+		"mode: set\n",
+		"mode: count\nsize: 0x0\n",
+		"mode: count\nsize: 81x25\n",
+		"mode: count\nsize: 100000000x100000000\n",
+		"mode: count\nsize: 80x25\n0,0 '&'\n",
+		"mode: count\nsize: 80x25\n80,0 '&' 1\n",
+		"mode: count\nsize: 80x25\n0,0 & 1\n",
+		"mode: count\nsize: 80x25\n0,0 ' ' 1\n",
+		"mode: count\nsize: 80x25\n0,0 '&' -1\n",
+	} {
+		_, err := ReadCoverageProfile(strings.NewReader(profile), Width*Height)
+		if !errors.Is(err, ErrInvalidProfile) {
+			t.Fatalf("expected ErrInvalidProfile for %q, got %v", profile, err)
+		}
+	}
+
+	// the default limit applies without an explicit one
+	_, err = ReadCoverageProfile(strings.NewReader("mode: count\nsize: 100000000x100000000\n"), 0)
+	if !errors.Is(err, ErrInvalidProfile) {
+		t.Fatalf("expected ErrInvalidProfile, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package bef93
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// diagnostic excerpt size around the error location
+const (
+	diagRows     = 2  // rows above and below
+	diagCols     = 30 // columns left and right
+	diagMaxStack = 8  // max number of stack values shown
+)
+
+// FormatDiagnostic renders a human readable, multi line description of err.
+// For a CompilationError or RuntimeError, this includes an excerpt of the code
+// around the error location, with the failing cell marked.
+// For a RuntimeError, it also includes the direction, string mode,
+// step count and the values on top of the stack.
+// Other errors are rendered using err.Error().
+func FormatDiagnostic(err error) string {
+	var rerr *RuntimeError
+	if errors.As(err, &rerr) {
+		return formatRuntimeError(rerr)
+	}
+
+	var cerr *CompilationError
+	if errors.As(err, &cerr) {
+		return formatCompilationError(cerr)
+	}
+
+	return err.Error()
+}
+
+func formatCompilationError(e *CompilationError) string {
+	b := strings.Builder{}
+
+	fmt.Fprintf(&b, "compilation error at (%d, %d): %s\n", e.LocX, e.LocY, e.Msg)
+
+	if e.LocY >= 0 && e.LocY < len(e.lines) {
+		lines := make([][]rune, len(e.lines))
+		for i, l := range e.lines {
+			lines[i] = []rune(l)
+		}
+		writeExcerpt(&b, lines, e.LocX, e.LocY)
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func formatRuntimeError(e *RuntimeError) string {
+	b := strings.Builder{}
+
+	fmt.Fprintf(&b, "%s\n", e.Error())
+	writeExcerpt(&b, e.Prog.code, e.LocX, e.LocY)
+
+	state := e.state
+	strMode := "off"
+	if state.StrMode {
+		strMode = "on"
+	}
+	fmt.Fprintf(&b, "direction: %s, string mode: %s, steps: %d\n", state.Dir, strMode, state.Steps)
+	fmt.Fprintf(&b, "stack (%d values, top first):", len(state.Stack))
+	for i := len(state.Stack) - 1; i >= 0 && i >= len(state.Stack)-diagMaxStack; i-- {
+		fmt.Fprintf(&b, " %d", state.Stack[i])
+	}
+	if len(state.Stack) > diagMaxStack {
+		b.WriteString(" ...")
+	}
+
+	return b.String()
+}
+
+// writeExcerpt writes the rows of code around (x, y), marking the cell at (x, y).
+func writeExcerpt(b *strings.Builder, code [][]rune, x, y int) {
+	y0, y1 := max(y-diagRows, 0), min(y+diagRows+1, len(code))
+	x0 := max(x-diagCols, 0)
+
+	numSz := len(fmt.Sprint(y1 - 1))
+	gutter := strings.Repeat(" ", numSz) + " |"
+
+	b.WriteString(gutter + "\n")
+	for row := y0; row < y1; row++ {
+		line := code[row]
+		x1 := min(x+diagCols+1, len(line))
+
+		excerpt := []rune{}
+		if x0 < x1 {
+			excerpt = line[x0:x1]
+		}
+
+		l := fmt.Sprintf("%*d | %s", numSz, row, printable(excerpt))
+		b.WriteString(strings.TrimRight(l, " ") + "\n")
+		if row == y {
+			fmt.Fprintf(b, "%s %s^\n", gutter, strings.Repeat(" ", x-x0))
+		}
+	}
+	b.WriteString(gutter + "\n")
+}
+
+// printable replaces non-printable runes with '?'.
+func printable(runes []rune) string {
+	b := strings.Builder{}
+	for _, r := range runes {
+		if unicode.IsPrint(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('?')
+		}
+	}
+	return b.String()
+}
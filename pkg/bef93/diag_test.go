@@ -0,0 +1,57 @@
+package bef93
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_FormatDiagnostic_RuntimeError(t *testing.T) {
+	_, _, err := exec2out(t, `12"ab"  3 *x@`, Opts{}, "")
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+
+	const expected = `runtime error at (11, 0): unknown opcode: 'x' (120)
+  |
+0 | 12"ab"  3 *x@
+  |            ^
+1 |
+2 |
+  |
+direction: right, string mode: off, steps: 12
+stack (4 values, top first): 294 97 2 1`
+
+	if FormatDiagnostic(err) != expected {
+		t.Fatalf("should be equal:\n%s", FormatDiagnostic(err))
+	}
+}
+
+func Test_FormatDiagnostic_CompilationError(t *testing.T) {
+	_, err := NewProg("abc\n  ö", Opts{})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+
+	const expected = `compilation error at (2, 1): code contains non-ascii characters
+  |
+0 | abc
+1 |   ö
+  |   ^
+  |`
+
+	if FormatDiagnostic(err) != expected {
+		t.Fatalf("should be equal:\n%s", FormatDiagnostic(err))
+	}
+}
+
+func Test_FormatDiagnostic_Other(t *testing.T) {
+	if FormatDiagnostic(errors.New("some error")) != "some error" {
+		t.Fatal("should be equal")
+	}
+
+	_, err := NewProg(strings.Repeat("#", Width+1), Opts{})
+	if FormatDiagnostic(err) != "compilation error at (81, 1): program code is too large" {
+		t.Fatalf("should be equal:\n%s", FormatDiagnostic(err))
+	}
+}
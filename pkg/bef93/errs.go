@@ -8,18 +8,20 @@ type CompilationError struct {
 	LocX, LocY int    // error location in code
 
 	cause error
+	lines []string // source code lines, for diagnostics
 }
 
 // compile time interface check
 var _ error = (*CompilationError)(nil)
 
-func newCompilationError(err error, locX, locY int) *CompilationError {
+func newCompilationError(err error, locX, locY int, lines []string) *CompilationError {
 	return &CompilationError{
 		Msg:  err.Error(),
 		LocX: locX,
 		LocY: locY,
 
 		cause: err,
+		lines: lines,
 	}
 }
 
@@ -34,6 +36,8 @@ type RuntimeError struct {
 	LocX, LocY int    // error location in code
 
 	cause error
+
+	state ProcState // interpreter state at time of error
 }
 
 // compile time interface check
@@ -44,3 +48,22 @@ func (e *RuntimeError) Error() string {
 }
 
 func (e *RuntimeError) Unwrap() error { return e.cause }
+
+// Dir returns the direction of the PC at time of error.
+func (e *RuntimeError) Dir() Direction { return e.state.Dir }
+
+// StrMode returns whether string mode was active at time of error.
+func (e *RuntimeError) StrMode() bool { return e.state.StrMode }
+
+// Stack returns a copy of the stack at time of error, bottom first.
+func (e *RuntimeError) Stack() []int64 {
+	ret := make([]int64, len(e.state.Stack))
+	copy(ret, e.state.Stack)
+	return ret
+}
+
+// Steps returns the number of instructions started until the error.
+// If an instruction failed, it is included. Errors raised between
+// instructions, like ErrStepLimitExceeded or a context which is done
+// before the next instruction, do not count an additional instruction.
+func (e *RuntimeError) Steps() int64 { return e.state.Steps }
@@ -2,6 +2,7 @@ package bef93
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -13,12 +14,16 @@ import (
 // Common errors returned by Exec().
 // Will be wrapped in a RuntimeError, so use errors.Is/As().
 var (
-	ErrTerminated     = errors.New("process already executed")
-	ErrUnknownOpCode  = errors.New("unknown opcode")
-	ErrDivZero        = errors.New("division by zero")
-	ErrWroteNothing   = errors.New("wrote 0 bytes")
-	ErrOutOfBounds    = errors.New("'p' or 'g' operation out of bounds")
-	ErrInvalidUnicode = errors.New("unable to decode input as valid utf-8 unicode")
+	ErrTerminated        = errors.New("process already executed")
+	ErrUnknownOpCode     = errors.New("unknown opcode")
+	ErrDivZero           = errors.New("division by zero")
+	ErrWroteNothing      = errors.New("wrote 0 bytes")
+	ErrOutOfBounds       = errors.New("'p' or 'g' operation out of bounds")
+	ErrInvalidUnicode    = errors.New("unable to decode input as valid utf-8 unicode")
+	ErrStepLimitExceeded = errors.New("step limit exceeded")
+	ErrStackOverflow     = errors.New("stack overflow")
+	// Wraps input and output errors if Opts.TerminateOnIOErr is set.
+	ErrIO = errors.New("I/O error")
 )
 
 var (
@@ -26,26 +31,168 @@ var (
 	errTerminated = errors.New("process terminated")
 )
 
-// Exec executes a process.
+// Exec executes a process until it terminates.
 // Can loop forever if the contained program does so.
 // Returns nil on successful termination.
-// Exec() can be called only once on a proc.
+// Exec() can be called after some manual Step() calls to resume execution,
+// but only until the program has terminated or failed.
 // You need to construct a new proc to execute again.
+// If a breakpoint is hit, returns a RuntimeError wrapping ErrBreakpoint,
+// after which execution can be resumed, see AddBreakpoint().
 func (p *Proc) Exec() error {
+	return p.ExecContext(context.Background())
+}
+
+// ExecContext is like Exec(), but stops execution as soon as ctx is done.
+// The context is checked between instructions and while blocked on reading
+// input. In that case, a RuntimeError wrapping ctx.Err() is returned, and the
+// proc is terminated.
+// A read which was blocked when ctx was done keeps running in the background,
+// the input it reads (e.g. a whole line for '&') is consumed and lost.
+// PeekInput() waits for such a read to finish.
+func (p *Proc) ExecContext(ctx context.Context) error {
 	if p.done {
 		return ErrTerminated
 	}
-	defer func() { p.done = true }()
 
+	p.ctx = ctx
+	defer func() { p.ctx = nil }()
+
+	ctxDone := ctx.Done()
 	for {
-		err := p.step()
-		if err == errTerminated {
-			return nil
+		// nil for context.Background()
+		if ctxDone != nil {
+			select {
+			case <-ctxDone:
+				p.done = true
+				return p.newRuntimeError(ctx.Err())
+			default:
+			}
+		}
+
+		if len(p.breakpoints) > 0 {
+			if err := p.checkBreakpoints(); err != nil {
+				return err
+			}
+		}
+
+		var done bool
+		var err error
+		if p.compiled != nil && len(p.breakpoints) == 0 {
+			done, err = p.compiled.run(p, compiledBatchSteps)
+		} else {
+			done, err = p.Step()
 		}
 		if err != nil {
 			return err
 		}
+		if done {
+			return nil
+		}
+
+		if p.watchHit != nil {
+			return p.newRuntimeError(p.watchHit)
+		}
+	}
+}
+
+// Step executes exactly one instruction.
+// Returns true if the program has terminated, i.e. if the executed
+// instruction was '@'.
+// Errors are the same as the ones returned by Exec(), after an error
+// the proc is terminated.
+func (p *Proc) Step() (bool, error) {
+	if p.done {
+		return true, ErrTerminated
+	}
+
+	p.watchHit = nil
+	if p.compiled != nil {
+		return p.compiled.run(p, 1)
+	}
+
+	if _, err := p.stepBudget(1); err != nil {
+		return true, err
+	}
+	p.beginStep()
+	op := p.currentOp()
+	err := p.endStep(op, p.execOp(op))
+	return p.done, err
+}
+
+// stepBudget returns how many of the next n instructions may be executed
+// before Opts.MaxSteps is reached. If it is already reached, the proc is
+// terminated and an error is returned.
+func (p *Proc) stepBudget(n int) (int, error) {
+	if limit := p.prog.opts.MaxSteps; limit > 0 {
+		if p.steps >= limit {
+			p.done = true
+			return 0, p.newRuntimeError(fmt.Errorf("%w: %d", ErrStepLimitExceeded, limit))
+		}
+		if left := limit - p.steps; left < int64(n) {
+			n = int(left)
+		}
+	}
+	return n, nil
+}
+
+// beginStep does the bookkeeping before executing an instruction, for all engines.
+// Small enough to be inlined, the journal and observers are handled by beforeStepHooks().
+func (p *Proc) beginStep() {
+	if p.journal != nil || p.observers != nil {
+		p.beforeStepHooks()
+	}
+	p.steps++
+}
+
+func (p *Proc) beforeStepHooks() {
+	if p.journal != nil {
+		p.record()
+	}
+	if len(p.observers) > 0 {
+		p.notifyBeforeStep()
+	}
+}
+
+// endStep does the bookkeeping after executing the instruction op, which
+// returned err, for all engines. The proc is done after '@' or an error,
+// returns err unless it is errTerminated.
+// Small enough to be inlined, like beginStep().
+func (p *Proc) endStep(op opcode, err error) error {
+	if err != nil || p.observers != nil {
+		return p.afterStepHooks(op, err)
+	}
+	return nil
+}
+
+func (p *Proc) afterStepHooks(op opcode, err error) error {
+	if err == errTerminated {
+		p.done = true
+	} else if err != nil {
+		p.done = true
+		if len(p.observers) > 0 {
+			p.notifyFail(op, err)
+		}
+		return err
 	}
+
+	if len(p.observers) > 0 {
+		p.notifyAfterStep(op)
+	}
+	return nil
+}
+
+// StepN executes up to n instructions, see Step().
+// Stops early if the program terminates or fails.
+func (p *Proc) StepN(n int) (bool, error) {
+	for i := 0; i < n; i++ {
+		done, err := p.Step()
+		if done || err != nil {
+			return done, err
+		}
+	}
+
+	return p.done, nil
 }
 
 func (p *Proc) newRuntimeError(err error) *RuntimeError {
@@ -56,45 +203,80 @@ func (p *Proc) newRuntimeError(err error) *RuntimeError {
 		LocY: p.pcY,
 
 		cause: err,
+		state: p.State(),
 	}
 }
 
+// newIOError wraps an I/O error err in ErrIO.
+func (p *Proc) newIOError(err error) *RuntimeError {
+	return p.newRuntimeError(fmt.Errorf("%w: %w", ErrIO, err))
+}
+
 func (p *Proc) currentOp() opcode {
 	return opcode(p.prog.code[p.pcY][p.pcX])
 }
 
-func (p *Proc) advancePC() {
+// movePC moves the PC one cell into the current direction, without wrapping around.
+func (p *Proc) movePC() {
 	switch p.dir {
-	case dirRight:
-		p.pcX = (p.pcX + 1) % p.prog.w
-	case dirDown:
-		p.pcY = (p.pcY + 1) % p.prog.h
-	case dirLeft:
-		p.pcX = (p.pcX - 1 + p.prog.w) % p.prog.w
-	case dirUp:
-		p.pcY = (p.pcY - 1 + p.prog.h) % p.prog.h
+	case DirRight:
+		p.pcX++
+	case DirDown:
+		p.pcY++
+	case DirLeft:
+		p.pcX--
+	case DirUp:
+		p.pcY--
 	}
 }
 
-func (p *Proc) step() error {
-	op := p.currentOp()
-	iop := int64(op)
+// advancePC moves the PC one cell into the current direction, wrapping around at the edges.
+// Like in the reference implementation, negative coordinates wrap to the last column/row,
+// this only makes a difference if the PC was already outside the grid (see opSkip).
+func (p *Proc) advancePC() {
+	p.movePC()
 
-	if p.strMode && op != opStr {
-		p.stack.push(iop)
-	} else if strings.Contains("0123456789", string(op)) {
-		p.stack.push(iop - int64('0'))
+	if p.pcX < 0 {
+		p.pcX = p.prog.w - 1
 	} else {
-		err := p.handleOp(op)
-		if err != nil {
-			return err
-		}
+		p.pcX %= p.prog.w
+	}
+
+	if p.pcY < 0 {
+		p.pcY = p.prog.h - 1
+	} else {
+		p.pcY %= p.prog.h
+	}
+}
+
+// execOp executes op and advances the PC.
+func (p *Proc) execOp(op opcode) error {
+	if p.strMode && op != opStr {
+		p.stack.push(int64(op))
+	} else if err := lookupOp(op)(p, op); err != nil {
+		return err
+	}
+
+	if err := p.checkStackDepth(); err != nil {
+		return err
 	}
 
 	p.advancePC()
 	return nil
 }
 
+func (p *Proc) checkStackDepth() error {
+	if p.prog.opts.MaxStackDepth > 0 && p.stack.sp > p.prog.opts.MaxStackDepth {
+		return p.stackOverflowError()
+	}
+	return nil
+}
+
+// stackOverflowError is separate from checkStackDepth() to allow inlining the latter.
+func (p *Proc) stackOverflowError() error {
+	return p.newRuntimeError(fmt.Errorf("%w: limit is %d", ErrStackOverflow, p.prog.opts.MaxStackDepth))
+}
+
 func readInt(in *bufio.Reader) (int64, error) {
 	l, err := in.ReadString('\n')
 
@@ -110,221 +292,237 @@ func readInt(in *bufio.Reader) (int64, error) {
 	return val, nil
 }
 
-func (p *Proc) handleOp(op opcode) error {
-	switch op {
-	case opAdd:
-		a, b := p.stack.pop2()
-		p.stack.push(a + b)
-	case opSub:
-		a, b := p.stack.pop2()
-		p.stack.push(b - a)
-	case opMul:
-		a, b := p.stack.pop2()
-		p.stack.push(a * b)
-	case opDiv:
-		a, b := p.stack.pop2()
-		if a != 0 {
-			p.stack.push(b / a)
-			return nil
-		}
+// putGetCell returns the cell addressed by a 'p' or 'g' operation,
+// and false if the coordinates are out of bounds.
+func (p *Proc) putGetCell(x, y int64) (int64, int64, bool) {
+	w, h := int64(p.prog.w), int64(p.prog.h)
 
-		if p.prog.opts.DisallowDivZero {
-			return p.newRuntimeError(fmt.Errorf("%w: %d / %d", ErrDivZero, a, b))
+	if !p.prog.opts.NoFixOffByOne {
+		if x < 0 || x >= w || y < 0 || y >= h {
+			return 0, 0, false
 		}
+		return x, y, true
+	}
 
-		fmt.Fprintf(p.outErr, "What do you want %d/0 to be?\n", b)
-		b, err := readInt(p.in)
-		if err != nil {
-			if p.prog.opts.TerminateOnIOErr {
-				return p.newRuntimeError(err)
-			}
+	// Old versions of the reference implementation check x <= w and y <= h,
+	// and then index the playfield as a flat buffer,
+	// so x == w addresses the first cell of the next row.
+	// Indices past the end of the buffer are undefined behavior there,
+	// we treat them as out of bounds.
+	if x < 0 || x > w || y < 0 || y > h {
+		return 0, 0, false
+	}
+	i := y*w + x
+	if i >= w*h {
+		return 0, 0, false
+	}
+	return i % w, i / w, true
+}
 
-			b = 0
-		}
-		p.stack.push(b)
-	case opMod:
-		a, b := p.stack.pop2()
-		// in the reference implementation, this is not handled and would crash
-		if a == 0 {
-			return p.newRuntimeError(fmt.Errorf("%w: %d %% %d", ErrDivZero, a, b))
-		}
-		p.stack.push(b % a)
-	case opNot:
-		a := p.stack.pop()
-		if a == 0 {
-			p.stack.push(1)
-		} else {
-			p.stack.push(0)
-		}
-	case opGt:
-		a, b := p.stack.pop2()
-		if b > a {
-			p.stack.push(1)
-		} else {
-			p.stack.push(0)
-		}
-	case opRight:
-		p.dir = dirRight
-	case opLeft:
-		p.dir = dirLeft
-	case opUp:
-		p.dir = dirUp
-	case opDown:
-		p.dir = dirDown
-	case opRand:
-		p.dir = direction(p.rand.Intn(int(dirEND)))
-	case opRif:
-		a := p.stack.pop()
-		if a == 0 {
-			p.dir = dirRight
-		} else {
-			p.dir = dirLeft
-		}
-	case opDif:
-		a := p.stack.pop()
-		if a == 0 {
-			p.dir = dirDown
-		} else {
-			p.dir = dirUp
-		}
-	case opStr:
-		p.strMode = !p.strMode
-	case opDup:
-		a := p.stack.pop()
-		p.stack.push(a)
-		p.stack.push(a)
-	case opSwp:
-		a, b := p.stack.pop2()
-		p.stack.push(a)
-		p.stack.push(b)
-	case opPop:
-		_ = p.stack.pop()
-	case opPopWrtInt:
-		a := p.stack.pop()
-		str := []byte(fmt.Sprintf("%d ", a))
-		n, err := p.out.Write(str)
+// wait runs a blocking read on p.in.
+// If called from within ExecContext(), returns early with the context error
+// if the context is done before the read finishes.
+// The read will then continue in the background and its result is discarded,
+// p.abandonedRead is closed when it finishes.
+func (p *Proc) wait(read func()) error {
+	if p.ctx == nil || p.ctx.Done() == nil {
+		read()
+		return nil
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		read()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-p.ctx.Done():
+		p.abandonedRead = finished
+		return p.ctx.Err()
+	}
+}
+
+// readDivZero reads the result of a division by zero from p.in.
+func (p *Proc) readDivZero() (int64, error) {
+	var val int64
+	var err error
+	if cerr := p.wait(func() { val, err = readInt(p.in) }); cerr != nil {
+		return 0, p.newRuntimeError(cerr)
+	}
+	if err != nil {
 		if p.prog.opts.TerminateOnIOErr {
-			if err != nil {
-				return p.newRuntimeError(err)
-			}
-			if n == 0 {
-				return p.newRuntimeError(ErrWroteNothing)
-			}
+			return 0, p.newIOError(err)
 		}
-	case opPopWrtChr:
-		chr := p.stack.pop()
-		c := rune(chr)
-		if !p.prog.opts.AllowUnicode {
-			c = rune(byte(c))
-		}
-		n, err := p.out.Write([]byte(string([]rune{c})))
+
+		val = 0
+	}
+
+	return val, nil
+}
+
+// readNr reads a number from p.in for the '&' operation.
+func (p *Proc) readNr() (int64, error) {
+	var val int64
+	var err error
+	if cerr := p.wait(func() { val, err = readInt(p.in) }); cerr != nil {
+		return 0, p.newRuntimeError(cerr)
+	}
+	if err != nil {
 		if p.prog.opts.TerminateOnIOErr {
-			if err != nil {
-				return p.newRuntimeError(err)
-			}
-			if n == 0 {
-				return p.newRuntimeError(ErrWroteNothing)
-			}
-		}
-	case opSkip:
-		p.advancePC()
-	case opPut:
-		y, x := p.stack.pop2()
-		val := p.stack.pop()
-
-		outOfBounds := x > int64(p.prog.h) || x < 0 || y > int64(p.prog.w) || y < 0
-		if outOfBounds {
-			if p.prog.opts.TerminateOnPutGetOutOfBounds {
-				return p.newRuntimeError(ErrOutOfBounds)
-			}
-			return nil
+			return 0, p.newIOError(err)
 		}
 
-		if !p.prog.opts.AllowUnicode {
-			p.prog.code[y][x] = rune(byte(val))
+		if p.prog.opts.ReadErrorUndefined {
+			// simulate "undefined" by using rand
+			val = randInt63(p.rand)
+			if p.rand.Intn(2) == 0 {
+				val = -val
+			}
 		} else {
-			p.prog.code[y][x] = rune(val)
+			val = -1
 		}
-	case opGet:
-		y, x := p.stack.pop2()
-		outOfBounds := x > int64(p.prog.h) || x < 0 || y > int64(p.prog.w) || y < 0
+	}
 
-		if outOfBounds {
-			if p.prog.opts.TerminateOnPutGetOutOfBounds {
-				return p.newRuntimeError(ErrOutOfBounds)
-			}
-			p.stack.push(0)
-			return nil
-		}
+	return val, nil
+}
 
-		val := p.prog.code[y][x]
-		if !p.prog.opts.AllowUnicode {
-			p.stack.push(int64(byte(val)))
-		} else {
-			p.stack.push(int64(val))
+// readChr reads a character from p.in for the '~' operation.
+func (p *Proc) readChr() (int64, error) {
+	if !p.prog.opts.AllowUnicode {
+		var b byte
+		var err error
+		if cerr := p.wait(func() { b, err = p.in.ReadByte() }); cerr != nil {
+			return 0, p.newRuntimeError(cerr)
 		}
-	case opReadNr:
-		val, err := readInt(p.in)
 		if err != nil {
 			if p.prog.opts.TerminateOnIOErr {
-				return p.newRuntimeError(err)
+				return 0, p.newIOError(err)
 			}
 
-			if p.prog.opts.ReadErrorUndefined {
-				// simulate "undefined" by using rand
-				val = p.rand.Int63()
-				if p.rand.Intn(2) == 0 {
-					val = -val
-				}
-			} else {
-				val = -1
-			}
+			// simulate EOF
+			return -1, nil
 		}
-		p.stack.push(val)
-	case opReadChr:
-		if !p.prog.opts.AllowUnicode {
-			b, err := p.in.ReadByte()
-			if err != nil {
-				if p.prog.opts.TerminateOnIOErr {
-					return p.newRuntimeError(err)
-				}
-
-				// simulate EOF
-				p.stack.push(-1)
-				return nil
-			}
 
-			p.stack.push(int64(b))
-			return nil
-		}
+		return int64(b), nil
+	}
 
-		// handle unicode
-		r, _, err := p.in.ReadRune()
-		if r == unicode.ReplacementChar {
-			err = ErrInvalidUnicode
+	// handle unicode
+	var r rune
+	var err error
+	if cerr := p.wait(func() { r, _, err = p.in.ReadRune() }); cerr != nil {
+		return 0, p.newRuntimeError(cerr)
+	}
+	if r == unicode.ReplacementChar {
+		err = ErrInvalidUnicode
+	}
+	if err != nil {
+		if p.prog.opts.TerminateOnIOErr {
+			return 0, p.newIOError(err)
 		}
+
+		// simulate EOF
+		return -1, nil
+	}
+
+	return int64(r), nil
+}
+
+// output writes str for an output operation.
+func (p *Proc) output(str []byte) error {
+	n, err := p.out.Write(str)
+	if n > 0 {
+		p.notifyOutput(str[:n])
+	}
+	if p.prog.opts.TerminateOnIOErr {
 		if err != nil {
-			if p.prog.opts.TerminateOnIOErr {
-				return p.newRuntimeError(err)
-			}
+			return p.newIOError(err)
+		}
+		if n == 0 {
+			return p.newIOError(ErrWroteNothing)
+		}
+	}
+	return nil
+}
 
-			// simulate EOF
-			p.stack.push(-1)
-			return nil
+// pushInput pushes a value obtained from an input operation.
+func (p *Proc) pushInput(val int64) {
+	p.stack.push(val)
+	p.notifyInput(val)
+}
+
+// divZero handles a division of b by zero for the '/' operation.
+func (p *Proc) divZero(b int64) error {
+	if p.prog.opts.DisallowDivZero {
+		return p.newRuntimeError(fmt.Errorf("%w: %d / %d", ErrDivZero, 0, b))
+	}
+
+	fmt.Fprintf(p.outErr, "What do you want %d/0 to be?\n", b)
+	val, err := p.input(p.readDivZero)
+	if err != nil {
+		return err
+	}
+	p.pushInput(val)
+	return nil
+}
+
+// modZero handles a modulo of b by zero for the '%' operation.
+func (p *Proc) modZero(b int64) error {
+	// in the reference implementation, this is not handled and would crash
+	return p.newRuntimeError(fmt.Errorf("%w: %d %% %d", ErrDivZero, 0, b))
+}
+
+// put executes the 'p' operation.
+func (p *Proc) put() error {
+	y, x := p.stack.pop2()
+	val := p.stack.pop()
+
+	x, y, ok := p.putGetCell(x, y)
+	if !ok {
+		if p.prog.opts.TerminateOnPutGetOutOfBounds {
+			return p.newRuntimeError(ErrOutOfBounds)
 		}
+		return nil
+	}
 
-		p.stack.push(int64(r))
-	case opEnd:
-		return errTerminated
-	case opWhitespace:
-		// do nothing
-	default:
-		if p.prog.opts.IgnoreUnsupportedInstructions {
-			// do nothing
-		} else {
-			return p.newRuntimeError(fmt.Errorf("%w: '%s' (%d)", ErrUnknownOpCode, string(op), int64(op)))
+	r := rune(val)
+	if !p.prog.opts.AllowUnicode {
+		r = rune(byte(val))
+	}
+	p.notifyPut(int(x), int(y), p.prog.code[y][x], r)
+	if p.journal != nil {
+		p.recordPut(int(x), int(y), p.prog.code[y][x])
+	}
+	p.prog.code[y][x] = r
+	if p.compiled != nil {
+		p.compiled.update(&p.prog, int(x), int(y))
+	}
+	if len(p.breakpoints) > 0 {
+		p.checkWatchpoints(int(x), int(y))
+	}
+	return nil
+}
+
+// get executes the 'g' operation.
+func (p *Proc) get() error {
+	y, x := p.stack.pop2()
+
+	x, y, ok := p.putGetCell(x, y)
+	if !ok {
+		if p.prog.opts.TerminateOnPutGetOutOfBounds {
+			return p.newRuntimeError(ErrOutOfBounds)
 		}
+		p.stack.push(0)
+		return nil
 	}
 
+	val := p.prog.code[y][x]
+	if !p.prog.opts.AllowUnicode {
+		p.stack.push(int64(byte(val)))
+	} else {
+		p.stack.push(int64(val))
+	}
 	return nil
 }
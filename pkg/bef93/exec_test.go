@@ -2,12 +2,34 @@ package bef93
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
 	"strings"
 	"testing"
+	"time"
 )
 
-// usage: proc, stdin, stdout, stderr := createProc(t, code)
-func createProc(t *testing.T, code string, opts Opts) (*Proc, *bytes.Buffer, *bytes.Buffer, *bytes.Buffer) {
+// execEngine selects the execution engine of the procs created by a test.
+type execEngine struct {
+	name string
+	opts []ProcOption
+}
+
+// forEachEngine runs test as a subtest for each execution engine.
+func forEachEngine(t *testing.T, test func(t *testing.T, e execEngine)) {
+	for _, e := range []execEngine{
+		{"Default", nil},
+		{"Precompiled", []ProcOption{WithPrecompiled()}},
+	} {
+		t.Run(e.name, func(t *testing.T) {
+			test(t, e)
+		})
+	}
+}
+
+// usage: proc, stdin, stdout, stderr := e.createProc(t, code)
+func (e execEngine) createProc(t *testing.T, code string, opts Opts) (*Proc, *bytes.Buffer, *bytes.Buffer, *bytes.Buffer) {
 	prog, err := NewProg(code, opts)
 	if prog == nil {
 		t.Fatalf("prog is nil")
@@ -17,13 +39,13 @@ func createProc(t *testing.T, code string, opts Opts) (*Proc, *bytes.Buffer, *by
 	}
 
 	stdin, stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}
-	proc := NewProc(prog, stdin, stdout, stderr)
+	proc := NewProc(prog, stdin, stdout, stderr, e.opts...)
 
 	return proc, stdin, stdout, stderr
 }
 
-func exec2out(t *testing.T, code string, opts Opts, in string) (string, string, error) {
-	proc, stdin, stdout, stderr := createProc(t, code, opts)
+func (e execEngine) exec2out(t *testing.T, code string, opts Opts, in string) (string, string, error) {
+	proc, stdin, stdout, stderr := e.createProc(t, code, opts)
 
 	stdin.Write([]byte(in))
 
@@ -31,169 +53,206 @@ func exec2out(t *testing.T, code string, opts Opts, in string) (string, string,
 	return stdout.String(), stderr.String(), err
 }
 
+// createProc is like execEngine.createProc(), using the default engine.
+func createProc(t *testing.T, code string, opts Opts) (*Proc, *bytes.Buffer, *bytes.Buffer, *bytes.Buffer) {
+	return execEngine{}.createProc(t, code, opts)
+}
+
+// exec2out is like execEngine.exec2out(), using the default engine.
+func exec2out(t *testing.T, code string, opts Opts, in string) (string, string, error) {
+	return execEngine{}.exec2out(t, code, opts, in)
+}
+
 func Test_Exec_HelloWorld(t *testing.T) {
-	const code = ` >25*"!dlrow ,olleH":v
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		const code = ` >25*"!dlrow ,olleH":v
                   v:,_@
                   >  ^`
 
-	out, _, err := exec2out(t, code, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
+		out, _, err := e.exec2out(t, code, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
 
-	if out != "Hello, world!\n" {
-		t.Fatal("should be equal")
-	}
+		if out != "Hello, world!\n" {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Add(t *testing.T) {
-	out, _, err := exec2out(t, `12+.@`, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, `12+.@`, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
 
-	if out != "3 " {
-		t.Fatal("should be equal")
-	}
+		if out != "3 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Sub(t *testing.T) {
-	out, _, err := exec2out(t, `32-.@`, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, `32-.@`, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
 
-	if out != "1 " {
-		t.Fatal("should be equal")
-	}
+		if out != "1 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Mul(t *testing.T) {
-	out, _, err := exec2out(t, `32*.@`, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, `32*.@`, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
 
-	if out != "6 " {
-		t.Fatal("should be equal")
-	}
+		if out != "6 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Div(t *testing.T) {
-	out, _, err := exec2out(t, `82/.@`, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, `82/.@`, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
 
-	if out != "4 " {
-		t.Fatal("should be equal")
-	}
+		if out != "4 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Div0_Ask(t *testing.T) {
-	out, outErr, err := exec2out(t, `80/.@`, Opts{}, "12")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, outErr, err := e.exec2out(t, `80/.@`, Opts{}, "12")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
 
-	if out != "12 " {
-		t.Fatal("should be equal")
-	}
-	if outErr != "What do you want 8/0 to be?\n" {
-		t.Fatal("should be equal")
-	}
+		if out != "12 " {
+			t.Fatal("should be equal")
+		}
+		if outErr != "What do you want 8/0 to be?\n" {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Div0_Fail(t *testing.T) {
-	_, _, err := exec2out(t, `80/.@`, Opts{DisallowDivZero: true}, "12")
-	if err == nil {
-		t.Fatalf("expected error")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		_, _, err := e.exec2out(t, `80/.@`, Opts{DisallowDivZero: true}, "12")
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+	})
 }
 
 func Test_Exec_Div0_IoErr(t *testing.T) {
-	out, _, err := exec2out(t, `80/.@`, Opts{}, "")
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, `80/.@`, Opts{}, "")
 
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
 
-	if out != "0 " {
-		t.Fatal("should be equal")
-	}
+		if out != "0 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Div0_IoErrFail(t *testing.T) {
-	_, _, err := exec2out(t, `80/.@`, Opts{TerminateOnIOErr: true}, "")
-	if err == nil {
-		t.Fatalf("expected error")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		_, _, err := e.exec2out(t, `80/.@`, Opts{TerminateOnIOErr: true}, "")
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+	})
 }
 
 func Test_Exec_Mod(t *testing.T) {
-	out, _, err := exec2out(t, `73%.@`, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, `73%.@`, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
 
-	if out != "1 " {
-		t.Fatal("should be equal")
-	}
+		if out != "1 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Mod0_Fail(t *testing.T) {
-	_, _, err := exec2out(t, `80%.@`, Opts{}, "")
-	if err == nil {
-		t.Fatalf("expected error")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		_, _, err := e.exec2out(t, `80%.@`, Opts{}, "")
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+	})
 }
 
 func Test_Exec_Not(t *testing.T) {
-	out, _, err := exec2out(t, `7!.@`, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "0 " {
-		t.Fatal("should be equal")
-	}
-
-	out, _, err = exec2out(t, `1!.@`, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "0 " {
-		t.Fatal("should be equal")
-	}
-
-	out, _, err = exec2out(t, `0!.@`, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "1 " {
-		t.Fatal("should be equal")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, `7!.@`, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "0 " {
+			t.Fatal("should be equal")
+		}
+
+		out, _, err = e.exec2out(t, `1!.@`, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "0 " {
+			t.Fatal("should be equal")
+		}
+
+		out, _, err = e.exec2out(t, `0!.@`, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "1 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Gt(t *testing.T) {
-	out, _, err := exec2out(t, "21`.@", Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "1 " {
-		t.Fatal("should be equal")
-	}
-
-	out, _, err = exec2out(t, "12`.@", Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "0 " {
-		t.Fatal("should be equal")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, "21`.@", Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "1 " {
+			t.Fatal("should be equal")
+		}
+
+		out, _, err = e.exec2out(t, "12`.@", Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "0 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Arrows(t *testing.T) {
-	code := strings.TrimSpace(`
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		code := strings.TrimSpace(`
 >  1  v
 @     2
 .
@@ -201,62 +260,72 @@ func Test_Exec_Arrows(t *testing.T) {
 .
 ^  3  <
 	`)
-	out, _, err := exec2out(t, code, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "3 2 1 " {
-		t.Fatal("should be equal")
-	}
+		out, _, err := e.exec2out(t, code, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "3 2 1 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Pop(t *testing.T) {
-	out, _, err := exec2out(t, "123$$$.@", Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "0 " {
-		t.Fatal("should be equal")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, "123$$$.@", Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "0 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_StrWtrChr(t *testing.T) {
-	out, _, err := exec2out(t, `"olleh",,,,,@`, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "hello" {
-		t.Fatal("should be equal")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, `"olleh",,,,,@`, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "hello" {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_WtrInt(t *testing.T) {
-	out, _, err := exec2out(t, `999**.@`, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "729 " {
-		t.Fatal("should be equal")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, `999**.@`, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "729 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Rif(t *testing.T) {
-	code := strings.TrimSpace(`
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		code := strings.TrimSpace(`
 >  1  v
   v0.2_3.@
   _7.@
 `)
-	out, _, err := exec2out(t, code, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "2 7 " {
-		t.Fatal("should be equal")
-	}
+		out, _, err := e.exec2out(t, code, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "2 7 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Dif(t *testing.T) {
-	code := strings.TrimSpace(`
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		code := strings.TrimSpace(`
 v
              @
      >   0   |
@@ -268,17 +337,19 @@ v
      @
 
 `)
-	out, _, err := exec2out(t, code, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "2 8 " {
-		t.Fatal("should be equal")
-	}
+		out, _, err := e.exec2out(t, code, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "2 8 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Skip(t *testing.T) {
-	code := strings.TrimSpace(`
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		code := strings.TrimSpace(`
 >   1  #23 .. v
 v     .. 89#  <
 #   @
@@ -288,103 +359,121 @@ v     .. 89#  <
 .   #
 >   ^
 `)
-	out, _, err := exec2out(t, code, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "3 1 8 0 5 0 7 " {
-		t.Fatal("should be equal")
-	}
+		out, _, err := e.exec2out(t, code, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "3 1 8 0 5 0 7 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Put(t *testing.T) {
-	code := strings.TrimSpace(`
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		code := strings.TrimSpace(`
 432pv
 v   <
 > "3" ..@
 `)
-	out, _, err := exec2out(t, code, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "4 0 " {
-		t.Fatal("should be equal")
-	}
+		out, _, err := e.exec2out(t, code, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "4 0 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Put_OutOfBounds(t *testing.T) {
-	code := strings.TrimSpace(`999** 999** 7 p 999** 999** g .@`)
-	out, _, err := exec2out(t, code, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "0 " {
-		t.Fatal("should be equal")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		code := strings.TrimSpace(`999** 999** 7 p 999** 999** g .@`)
+		out, _, err := e.exec2out(t, code, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "0 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Put_OutOfBounds_Err(t *testing.T) {
-	code := strings.TrimSpace(`999** 999** 7 p`)
-	_, _, err := exec2out(t, code, Opts{TerminateOnPutGetOutOfBounds: true}, "")
-	if err == nil {
-		t.Fatalf("expected error")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		code := strings.TrimSpace(`999** 999** 7 p`)
+		_, _, err := e.exec2out(t, code, Opts{TerminateOnPutGetOutOfBounds: true}, "")
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+	})
 }
 
 func Test_Exec_Get(t *testing.T) {
-	code := strings.TrimSpace(`
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		code := strings.TrimSpace(`
 83g,@
 
 
         7
 `)
-	out, _, err := exec2out(t, code, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "7" {
-		t.Fatal("should be equal")
-	}
+		out, _, err := e.exec2out(t, code, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "7" {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Get_OutOfBounds(t *testing.T) {
-	out, _, err := exec2out(t, `999** 999** g . @`, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "0 " {
-		t.Fatal("should be equal")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, `999** 999** g . @`, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "0 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 func Test_Exec_Get_OutOfBounds_Err(t *testing.T) {
-	_, _, err := exec2out(t, `999**999**g.@`, Opts{TerminateOnPutGetOutOfBounds: true}, "")
-	if err == nil {
-		t.Fatalf("expected error")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		_, _, err := e.exec2out(t, `999**999**g.@`, Opts{TerminateOnPutGetOutOfBounds: true}, "")
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+	})
 }
 
 func Test_Exec_AskNr(t *testing.T) {
-	out, _, err := exec2out(t, `&   &..@`, Opts{TerminateOnIOErr: true}, "76341\n987312\n")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "987312 76341 " {
-		t.Fatal("should be equal")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, `&   &..@`, Opts{TerminateOnIOErr: true}, "76341\n987312\n")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "987312 76341 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_AskChr(t *testing.T) {
-	out, _, err := exec2out(t, `~ ~,,@`, Opts{TerminateOnIOErr: true}, "ab")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "ba" {
-		t.Fatal("should be equal")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, `~ ~,,@`, Opts{TerminateOnIOErr: true}, "ab")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "ba" {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Wraparound(t *testing.T) {
-	code := strings.TrimSpace(`
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		code := strings.TrimSpace(`
 >                      v
                   
              1.   ^    >
@@ -392,66 +481,539 @@ func Test_Exec_Wraparound(t *testing.T) {
                   .
                   2
 `)
-	out, _, err := exec2out(t, code, Opts{}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "1 2 " {
-		t.Fatal("should be equal")
-	}
+		out, _, err := e.exec2out(t, code, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "1 2 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Unicode_Code(t *testing.T) {
-	code := `"dlröW olläH вба",,,,,,,,,,,,,,,@`
-	out, _, err := exec2out(t, code, Opts{AllowUnicode: true}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "абв Hällo Wörld" {
-		t.Fatal("should be equal")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		code := `"dlröW olläH вба",,,,,,,,,,,,,,,@`
+		out, _, err := e.exec2out(t, code, Opts{AllowUnicode: true}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "абв Hällo Wörld" {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Unicode_PutWrite(t *testing.T) {
-	code := strings.TrimSpace(`
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		code := strings.TrimSpace(`
 25*25**25**25*7*+4+  1 1 p v
 " "                        <        @,
 `)
-	out, _, err := exec2out(t, code, Opts{AllowUnicode: true}, "")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "в" {
-		t.Fatal("should be equal")
-	}
+		out, _, err := e.exec2out(t, code, Opts{AllowUnicode: true}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "в" {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Unicode_ReadWrite(t *testing.T) {
-	code := strings.TrimSpace(`~~~,,,@`)
-	out, _, err := exec2out(t, code, Opts{AllowUnicode: true}, "aвc")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "cвa" {
-		t.Fatal("should be equal")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		code := strings.TrimSpace(`~~~,,,@`)
+		out, _, err := e.exec2out(t, code, Opts{AllowUnicode: true}, "aвc")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "cвa" {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Unicode_Read_InvalidUtf8(t *testing.T) {
-	code := strings.TrimSpace(`~.@`)
-	out, _, err := exec2out(t, code, Opts{AllowUnicode: true}, "\xc3\x28")
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-	if out != "-1 " {
-		t.Fatal("should be equal")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		code := strings.TrimSpace(`~.@`)
+		out, _, err := e.exec2out(t, code, Opts{AllowUnicode: true}, "\xc3\x28")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "-1 " {
+			t.Fatal("should be equal")
+		}
+	})
 }
 
 func Test_Exec_Unicode_Read_InvalidUtf8_Err(t *testing.T) {
-	code := strings.TrimSpace(`~.@`)
-	_, _, err := exec2out(t, code, Opts{AllowUnicode: true, TerminateOnIOErr: true}, "\xc3\x28")
-	if err == nil {
-		t.Fatalf("expected error")
-	}
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		code := strings.TrimSpace(`~.@`)
+		_, _, err := e.exec2out(t, code, Opts{AllowUnicode: true, TerminateOnIOErr: true}, "\xc3\x28")
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+}
+
+func Test_Exec_Step(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		proc, _, stdout, _ := e.createProc(t, `12+.@`, Opts{})
+
+		for i := 0; i < 4; i++ {
+			done, err := proc.Step()
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if done {
+				t.Fatal("should not be done")
+			}
+		}
+		if stdout.String() != "3 " {
+			t.Fatal("should be equal")
+		}
+
+		done, err := proc.Step()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if !done {
+			t.Fatal("should be done")
+		}
+
+		_, err = proc.Step()
+		if !errors.Is(err, ErrTerminated) {
+			t.Fatal("expected ErrTerminated")
+		}
+	})
+}
+
+func Test_Exec_StepN_Resume(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		proc, _, stdout, _ := e.createProc(t, `1.2.3.@`, Opts{})
+
+		done, err := proc.StepN(2)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if done {
+			t.Fatal("should not be done")
+		}
+		if stdout.String() != "1 " {
+			t.Fatal("should be equal")
+		}
+
+		err = proc.Exec()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if stdout.String() != "1 2 3 " {
+			t.Fatal("should be equal")
+		}
+
+		err = proc.Exec()
+		if !errors.Is(err, ErrTerminated) {
+			t.Fatal("expected ErrTerminated")
+		}
+	})
+}
+
+func Test_Exec_StepN_Err(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		proc, _, _, _ := e.createProc(t, `x@`, Opts{})
+
+		done, err := proc.StepN(10)
+		if !errors.Is(err, ErrUnknownOpCode) {
+			t.Fatal("expected ErrUnknownOpCode")
+		}
+		if !done {
+			t.Fatal("should be done")
+		}
+	})
+}
+
+func Test_Exec_Context_Loop(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		proc, _, _, _ := e.createProc(t, `>v
+^<`, Opts{})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := proc.ExecContext(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expected context.DeadlineExceeded")
+		}
+
+		var rerr *RuntimeError
+		if !errors.As(err, &rerr) {
+			t.Fatal("expected RuntimeError")
+		}
+		if rerr.LocX > 1 || rerr.LocY > 1 {
+			t.Fatal("invalid location")
+		}
+
+		err = proc.Exec()
+		if !errors.Is(err, ErrTerminated) {
+			t.Fatal("expected ErrTerminated")
+		}
+	})
+}
+
+func Test_Exec_Context_BlockedRead(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		prog, err := NewProg(` &.@`, Opts{})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		in, inW := io.Pipe()
+		defer inW.Close()
+		proc := NewProc(prog, in, &bytes.Buffer{}, &bytes.Buffer{}, e.opts...)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+
+		err = proc.ExecContext(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatal("expected context.Canceled")
+		}
+
+		var rerr *RuntimeError
+		if !errors.As(err, &rerr) {
+			t.Fatal("expected RuntimeError")
+		}
+		if rerr.LocX != 1 || rerr.LocY != 0 {
+			t.Fatal("invalid location")
+		}
+	})
+}
+
+func Test_Exec_Context_BlockedRead_Input(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		prog, err := NewProg(`&.@`, Opts{})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		in, inW := io.Pipe()
+		defer inW.Close()
+		proc := NewProc(prog, in, &bytes.Buffer{}, &bytes.Buffer{}, e.opts...)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+
+		err = proc.ExecContext(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatal("expected context.Canceled")
+		}
+
+		// the abandoned read consumes the first line
+		go func() {
+			_, _ = inW.Write([]byte("12\n34\n"))
+		}()
+		if string(proc.PeekInput(3)) != "34\n" {
+			t.Fatal("should be equal")
+		}
+	})
+}
+
+func Test_Exec_MaxSteps(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		proc, _, stdout, _ := e.createProc(t, `1.2.3.@`, Opts{MaxSteps: 7})
+		err := proc.Exec()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if stdout.String() != "1 2 3 " {
+			t.Fatal("should be equal")
+		}
+		if proc.Steps() != 7 {
+			t.Fatal("invalid step count")
+		}
+
+		proc, _, stdout, _ = e.createProc(t, `1.2.3.@`, Opts{MaxSteps: 4})
+		err = proc.Exec()
+		if !errors.Is(err, ErrStepLimitExceeded) {
+			t.Fatal("expected ErrStepLimitExceeded")
+		}
+		if stdout.String() != "1 2 " {
+			t.Fatal("should be equal")
+		}
+		if proc.Steps() != 4 {
+			t.Fatal("invalid step count")
+		}
+	})
+}
+
+func Test_Exec_MaxStackDepth(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		out, _, err := e.exec2out(t, `123...@`, Opts{MaxStackDepth: 3}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "3 2 1 " {
+			t.Fatal("should be equal")
+		}
+
+		_, _, err = e.exec2out(t, `>1:v
+^  <`, Opts{MaxStackDepth: 100}, "")
+		if !errors.Is(err, ErrStackOverflow) {
+			t.Fatal("expected ErrStackOverflow")
+		}
+	})
+}
+
+func Test_Exec_Put_LastCell(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		// puts and gets (79, 24)
+		code := strings.TrimSpace(`"X"88*96++25*2*4+p 88*96++25*2*4+g,@`)
+		out, _, err := e.exec2out(t, code, Opts{TerminateOnPutGetOutOfBounds: true}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "X" {
+			t.Fatal("should be equal")
+		}
+	})
+}
+
+func Test_Exec_NoFixOffByOne_Put(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		// puts 'X' to (80, 0), then reads (0, 1)
+		code := `"X"85*2*0p01g,@`
+
+		out, _, err := e.exec2out(t, code, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != " " {
+			t.Fatal("should be equal")
+		}
+
+		_, _, err = e.exec2out(t, code, Opts{TerminateOnPutGetOutOfBounds: true}, "")
+		if !errors.Is(err, ErrOutOfBounds) {
+			t.Fatal("expected ErrOutOfBounds")
+		}
+
+		out, _, err = e.exec2out(t, code, Opts{NoFixOffByOne: true, TerminateOnPutGetOutOfBounds: true}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "X" {
+			t.Fatal("should be equal")
+		}
+	})
+}
+
+func Test_Exec_NoFixOffByOne_Get(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		// reads (80, 0) and (0, 25)
+		code := strings.TrimSpace(`
+85*2*0g. 055*g.@
+Z
+`)
+
+		out, _, err := e.exec2out(t, code, Opts{}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "0 0 " {
+			t.Fatal("should be equal")
+		}
+
+		out, _, err = e.exec2out(t, code, Opts{NoFixOffByOne: true}, "")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if out != "90 0 " {
+			t.Fatal("should be equal")
+		}
+
+		_, _, err = e.exec2out(t, code, Opts{NoFixOffByOne: true, TerminateOnPutGetOutOfBounds: true}, "")
+		if !errors.Is(err, ErrOutOfBounds) {
+			t.Fatal("expected ErrOutOfBounds")
+		}
+	})
+}
+
+// gridCode returns code of standard size with the given cells set.
+func gridCode(cells map[[2]int]rune) string {
+	grid := make([][]rune, Height)
+	for y := range grid {
+		grid[y] = []rune(strings.Repeat(" ", Width))
+	}
+
+	for xy, r := range cells {
+		grid[xy[1]][xy[0]] = r
+	}
+
+	lines := make([]string, Height)
+	for y, l := range grid {
+		lines[y] = string(l)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func Test_Exec_WrapHashInconsistently(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		tests := []struct {
+			name         string
+			cells        map[[2]int]rune
+			out, outIncs string
+		}{
+			{
+				name: "right at last column",
+				cells: map[[2]int]rune{
+					{0, 0}: '<', {79, 0}: '<', {78, 0}: 'v', {78, 1}: '>', {79, 1}: '#',
+					{0, 1}: '2', {1, 1}: '1', {2, 1}: '.', {3, 1}: '@',
+				},
+				out: "1 ", outIncs: "1 ",
+			},
+			{
+				name: "right before last column",
+				cells: map[[2]int]rune{
+					{0, 0}: '<', {79, 0}: '<', {78, 0}: '<', {77, 0}: 'v', {77, 1}: '>', {78, 1}: '#',
+					{79, 1}: '2', {0, 1}: '1', {1, 1}: '.', {2, 1}: '@',
+				},
+				out: "1 ", outIncs: "1 ",
+			},
+			{
+				name: "left at first column",
+				cells: map[[2]int]rune{
+					{0, 0}: '>', {1, 0}: 'v', {1, 1}: '<', {0, 1}: '#',
+					{79, 1}: '1', {78, 1}: '2', {77, 1}: '.', {76, 1}: '.', {75, 1}: '@',
+				},
+				out: "2 0 ", outIncs: "2 1 ",
+			},
+			{
+				name: "left before first column",
+				cells: map[[2]int]rune{
+					{0, 0}: '>', {1, 0}: '>', {2, 0}: 'v', {2, 1}: '<', {1, 1}: '#',
+					{0, 1}: '2', {79, 1}: '1', {78, 1}: '.', {77, 1}: '@',
+				},
+				out: "1 ", outIncs: "1 ",
+			},
+			{
+				name: "down at last row",
+				cells: map[[2]int]rune{
+					{0, 0}: '^', {0, 23}: '>', {1, 23}: 'v', {1, 24}: '#',
+					{1, 0}: '2', {1, 1}: '1', {1, 2}: '.', {1, 3}: '@',
+				},
+				out: "1 ", outIncs: "1 ",
+			},
+			{
+				name: "down before last row",
+				cells: map[[2]int]rune{
+					{0, 0}: '^', {0, 22}: '>', {1, 22}: 'v', {1, 23}: '#',
+					{1, 24}: '2', {1, 0}: '1', {1, 1}: '.', {1, 2}: '@',
+				},
+				out: "1 ", outIncs: "1 ",
+			},
+			{
+				name: "up at first row",
+				cells: map[[2]int]rune{
+					{0, 0}: '>', {1, 0}: 'v', {1, 1}: '>', {2, 1}: '^', {2, 0}: '#',
+					{2, 24}: '1', {2, 23}: '2', {2, 22}: '.', {2, 21}: '.', {2, 20}: '@',
+				},
+				out: "2 0 ", outIncs: "2 1 ",
+			},
+			{
+				name: "up before first row",
+				cells: map[[2]int]rune{
+					{0, 0}: '>', {1, 0}: 'v', {1, 2}: '>', {2, 2}: '^', {2, 1}: '#',
+					{2, 0}: '2', {2, 24}: '1', {2, 23}: '.', {2, 22}: '@',
+				},
+				out: "1 ", outIncs: "1 ",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				code := gridCode(tt.cells)
+
+				out, _, err := e.exec2out(t, code, Opts{MaxSteps: 1000}, "")
+				if err != nil {
+					t.Fatalf(err.Error())
+				}
+				if out != tt.out {
+					t.Fatalf("should be equal: %q", out)
+				}
+
+				out, _, err = e.exec2out(t, code, Opts{MaxSteps: 1000, WrapHashInconsistently: true}, "")
+				if err != nil {
+					t.Fatalf(err.Error())
+				}
+				if out != tt.outIncs {
+					t.Fatalf("should be equal: %q", out)
+				}
+			})
+		}
+	})
+}
+
+func Test_Exec_RuntimeError_State(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		_, _, err := e.exec2out(t, `v
+>"ba"12 0%@`, Opts{}, "")
+
+		var rerr *RuntimeError
+		if !errors.As(err, &rerr) {
+			t.Fatal("expected RuntimeError")
+		}
+
+		if rerr.Dir() != DirRight || rerr.StrMode() || rerr.Steps() != 11 {
+			t.Fatal("invalid state")
+		}
+
+		stack := rerr.Stack()
+		if len(stack) != 3 || stack[0] != 'b' || stack[1] != 'a' || stack[2] != 1 {
+			t.Fatalf("invalid stack %v", stack)
+		}
+
+		stack[0] = 0
+		if rerr.Stack()[0] != 'b' {
+			t.Fatal("stack should be a copy")
+		}
+	})
+}
+
+func Test_Exec_State(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, e execEngine) {
+		proc, _, _, _ := e.createProc(t, `v
+>"ab"12@`, Opts{})
+
+		_, err := proc.StepN(5)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		state := proc.State()
+		if state.PCX != 4 || state.PCY != 1 || state.Dir != DirRight || !state.StrMode || state.Steps != 5 || state.Done {
+			t.Fatalf("invalid state %+v", state)
+		}
+		if len(state.Stack) != 2 || state.Stack[0] != 'a' || state.Stack[1] != 'b' {
+			t.Fatalf("invalid stack %v", state.Stack)
+		}
+
+		err = proc.Exec()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		state = proc.State()
+		if state.PCX != 7 || state.StrMode || state.Steps != 9 || !state.Done {
+			t.Fatalf("invalid state %+v", state)
+		}
+		if len(state.Stack) != 4 || state.Stack[3] != 2 {
+			t.Fatalf("invalid stack %v", state.Stack)
+		}
+	})
 }
@@ -0,0 +1,171 @@
+package bef93
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by StepBack() and RunBackTo().
+var (
+	ErrNoJournal    = errors.New("journal not enabled")
+	ErrNotInJournal = errors.New("step not in journal")
+)
+
+// maximum number of values popped by a single instruction ('p')
+const maxPops = 3
+
+// journalEntry records everything needed to undo a step.
+type journalEntry struct {
+	pcX, pcY int
+	dir      Direction
+	strMode  bool
+	done     bool
+	steps    int64
+
+	// stack pointer and the values below it before the step
+	sp  int
+	top [maxPops]int64
+
+	put        bool
+	putX, putY int
+	putOld     rune
+	hasEvent   bool
+	event      Event
+}
+
+// journal is a ring buffer of journal entries.
+type journal struct {
+	entries    []journalEntry
+	start, len int
+}
+
+// WithJournal enables time travel debugging, see StepBack() and RunBackTo().
+// At most maxSteps steps are recorded, older steps are discarded.
+func WithJournal(maxSteps int) ProcOption {
+	return func(p *Proc) {
+		if maxSteps > 0 {
+			p.journal = &journal{entries: make([]journalEntry, maxSteps)}
+		}
+	}
+}
+
+// push adds a new entry, discarding the oldest one if full.
+func (j *journal) push() *journalEntry {
+	if j.len == len(j.entries) {
+		j.start = (j.start + 1) % len(j.entries)
+		j.len--
+	}
+
+	e := &j.entries[(j.start+j.len)%len(j.entries)]
+	*e = journalEntry{}
+	j.len++
+	return e
+}
+
+// last returns the newest entry.
+func (j *journal) last() *journalEntry {
+	return &j.entries[(j.start+j.len-1)%len(j.entries)]
+}
+
+// first returns the oldest entry.
+func (j *journal) first() *journalEntry {
+	return &j.entries[j.start]
+}
+
+// record adds a journal entry with the current state, before executing a step.
+func (p *Proc) record() {
+	e := p.journal.push()
+
+	e.pcX, e.pcY = p.pcX, p.pcY
+	e.dir = p.dir
+	e.strMode = p.strMode
+	e.done = p.done
+	e.steps = p.steps
+
+	e.sp = p.stack.sp
+	for i := 0; i < maxPops && i < p.stack.sp; i++ {
+		e.top[i] = p.stack.s[p.stack.sp-1-i]
+	}
+}
+
+// recordPut records a 'p' operation writing to (x, y) in the current journal entry.
+func (p *Proc) recordPut(x, y int, old rune) {
+	e := p.journal.last()
+	e.put = true
+	e.putX, e.putY = x, y
+	e.putOld = old
+}
+
+// undo restores the state before the step recorded in e.
+func (p *Proc) undo(e *journalEntry) {
+	p.pcX, p.pcY = e.pcX, e.pcY
+	p.dir = e.dir
+	p.strMode = e.strMode
+	p.done = e.done
+	p.steps = e.steps
+
+	p.stack.sp = e.sp
+	for i := 0; i < maxPops && i < e.sp; i++ {
+		p.stack.s[e.sp-1-i] = e.top[i]
+	}
+
+	if e.put {
+		p.prog.code[e.putY][e.putX] = e.putOld
+		if p.compiled != nil {
+			p.compiled.update(&p.prog, e.putX, e.putY)
+		}
+	}
+
+	if e.hasEvent {
+		// replay when executing forward again
+		p.redo = append(p.redo, e.event)
+		if p.recording != nil {
+			p.recording.Events = p.recording.Events[:len(p.recording.Events)-1]
+		}
+	}
+
+	p.watchHit = nil
+	// do not stop at breakpoints at this location when resuming
+	p.resumeSteps = p.steps + 1
+}
+
+// StepBack undoes the last executed instruction, including a failed one.
+// Requires the proc to be created using WithJournal().
+// Output which was already written is not undone.
+// Input values and random directions consumed by undone instructions
+// are replayed when executing forward again.
+func (p *Proc) StepBack() error {
+	if p.journal == nil {
+		return ErrNoJournal
+	}
+	if p.journal.len == 0 {
+		return ErrNotInJournal
+	}
+
+	p.undo(p.journal.last())
+	p.journal.len--
+
+	return nil
+}
+
+// RunBackTo undoes instructions until the given number of steps was executed,
+// see StepBack().
+// Returns an error if the step is not recorded in the journal, without
+// modifying the proc.
+func (p *Proc) RunBackTo(step int64) error {
+	if p.journal == nil {
+		return ErrNoJournal
+	}
+	if step > p.steps || (step < p.steps && (p.journal.len == 0 || step < p.journal.first().steps)) {
+		return fmt.Errorf("%w: %d", ErrNotInJournal, step)
+	}
+
+	for p.steps > step {
+		err := p.StepBack()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package bef93
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Journal_StepBack(t *testing.T) {
+	const code = `&:1+"x"45*0pv
+          @.?.@
+            .
+            @`
+
+	prog, err := NewProg(code, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	stdout := &bytes.Buffer{}
+	proc := NewProc(prog, strings.NewReader("5\n"), stdout, &bytes.Buffer{}, WithJournal(1000))
+
+	states := []ProcState{proc.State()}
+	codes := []string{proc.Prog().Code()}
+	for {
+		done, err := proc.Step()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		states = append(states, proc.State())
+		codes = append(codes, proc.Prog().Code())
+		if done {
+			break
+		}
+	}
+	out := stdout.String()
+
+	for i := len(states) - 2; i >= 0; i-- {
+		err := proc.StepBack()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if !reflect.DeepEqual(proc.State(), states[i]) {
+			t.Fatalf("invalid state at step %d: %+v, expected %+v", i, proc.State(), states[i])
+		}
+		if proc.Prog().Code() != codes[i] {
+			t.Fatalf("invalid code at step %d", i)
+		}
+	}
+
+	err = proc.StepBack()
+	if !errors.Is(err, ErrNotInJournal) {
+		t.Fatal("expected ErrNotInJournal")
+	}
+
+	// execute forward again, replaying input and random choices
+	stdout.Reset()
+	err = proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if stdout.String() != out {
+		t.Fatal("should be equal")
+	}
+	if !reflect.DeepEqual(proc.State(), states[len(states)-1]) {
+		t.Fatal("invalid state")
+	}
+}
+
+func Test_Journal_StepBackError(t *testing.T) {
+	proc, _, _, _ := createProc(t, `12 0%@`, Opts{})
+	proc = proc.Clone(&bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}, WithJournal(10))
+
+	err := proc.Exec()
+	if !errors.Is(err, ErrDivZero) {
+		t.Fatal("expected ErrDivZero")
+	}
+
+	err = proc.StepBack()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	state := proc.State()
+	if state.Done || state.PCX != 4 || !reflect.DeepEqual(state.Stack, []int64{1, 2, 0}) {
+		t.Fatalf("invalid state %+v", state)
+	}
+}
+
+func Test_Journal_RunBackTo(t *testing.T) {
+	prog, err := NewProg(`1234567@`, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	proc := NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}, WithJournal(3))
+	err = proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	err = proc.RunBackTo(4)
+	if !errors.Is(err, ErrNotInJournal) {
+		t.Fatal("expected ErrNotInJournal")
+	}
+	if proc.Steps() != 8 {
+		t.Fatal("should not be modified")
+	}
+
+	err = proc.RunBackTo(9)
+	if !errors.Is(err, ErrNotInJournal) {
+		t.Fatal("expected ErrNotInJournal")
+	}
+
+	err = proc.RunBackTo(5)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	state := proc.State()
+	if state.Done || state.Steps != 5 || state.PCX != 5 || len(state.Stack) != 5 {
+		t.Fatalf("invalid state %+v", state)
+	}
+
+	err = proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(proc.State().Stack) != 7 {
+		t.Fatal("invalid stack")
+	}
+}
+
+func Test_Journal_Disabled(t *testing.T) {
+	proc, _, _, _ := createProc(t, `@`, Opts{})
+
+	if !errors.Is(proc.StepBack(), ErrNoJournal) {
+		t.Fatal("expected ErrNoJournal")
+	}
+	if !errors.Is(proc.RunBackTo(0), ErrNoJournal) {
+		t.Fatal("expected ErrNoJournal")
+	}
+}
@@ -0,0 +1,111 @@
+package bef93
+
+// Observer receives callbacks during execution of a Proc, see WithObserver().
+// The state passed to the callbacks is only valid for the duration of the call,
+// its Stack must not be modified or retained.
+type Observer interface {
+	// BeforeStep is called before an instruction is executed.
+	BeforeStep(state ProcState)
+	// AfterStep is called after an instruction was executed successfully,
+	// see FailObserver for failed instructions.
+	AfterStep(state ProcState, op rune)
+	// OnPut is called when a 'p' operation writes to the grid.
+	OnPut(x, y int, old, val rune)
+	// OnOutput is called with the bytes written by a '.' or ',' operation,
+	// after they were written. Not called if nothing was written.
+	OnOutput(b []byte)
+	// OnInput is called with the value pushed by an input operation.
+	OnInput(val int64)
+}
+
+// FailObserver can be implemented by an Observer to be notified about
+// instructions which fail with an error.
+type FailObserver interface {
+	// OnFail is called instead of Observer.AfterStep() if the instruction op
+	// failed with err. The proc is terminated afterwards.
+	OnFail(state ProcState, op rune, err error)
+}
+
+// NopObserver implements Observer, but does nothing.
+// Embed it to implement only some of the callbacks.
+type NopObserver struct{}
+
+// compile time interface check
+var _ Observer = NopObserver{}
+
+// BeforeStep implements Observer.
+func (NopObserver) BeforeStep(ProcState) {}
+
+// AfterStep implements Observer.
+func (NopObserver) AfterStep(ProcState, rune) {}
+
+// OnPut implements Observer.
+func (NopObserver) OnPut(int, int, rune, rune) {}
+
+// OnOutput implements Observer.
+func (NopObserver) OnOutput([]byte) {}
+
+// OnInput implements Observer.
+func (NopObserver) OnInput(int64) {}
+
+// WithObserver attaches an Observer to a Proc.
+// Can be given multiple times, observers are called in order.
+func WithObserver(o Observer) ProcOption {
+	return func(p *Proc) {
+		p.observers = append(p.observers, o)
+	}
+}
+
+// stateView returns the current state, without copying the stack.
+func (p *Proc) stateView() ProcState {
+	return ProcState{
+		PCX:     p.pcX,
+		PCY:     p.pcY,
+		Dir:     p.dir,
+		StrMode: p.strMode,
+		Stack:   p.stack.s[:p.stack.sp],
+		Steps:   p.steps,
+		Done:    p.done,
+	}
+}
+
+func (p *Proc) notifyBeforeStep() {
+	state := p.stateView()
+	for _, o := range p.observers {
+		o.BeforeStep(state)
+	}
+}
+
+func (p *Proc) notifyAfterStep(op opcode) {
+	state := p.stateView()
+	for _, o := range p.observers {
+		o.AfterStep(state, rune(op))
+	}
+}
+
+func (p *Proc) notifyFail(op opcode, err error) {
+	state := p.stateView()
+	for _, o := range p.observers {
+		if f, ok := o.(FailObserver); ok {
+			f.OnFail(state, rune(op), err)
+		}
+	}
+}
+
+func (p *Proc) notifyPut(x, y int, old, val rune) {
+	for _, o := range p.observers {
+		o.OnPut(x, y, old, val)
+	}
+}
+
+func (p *Proc) notifyOutput(b []byte) {
+	for _, o := range p.observers {
+		o.OnOutput(b)
+	}
+}
+
+func (p *Proc) notifyInput(val int64) {
+	for _, o := range p.observers {
+		o.OnInput(val)
+	}
+}
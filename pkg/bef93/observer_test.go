@@ -0,0 +1,122 @@
+package bef93
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+type recordingObserver struct {
+	events []string
+}
+
+func (o *recordingObserver) BeforeStep(state ProcState) {
+	o.events = append(o.events, fmt.Sprintf("before (%d, %d) %v", state.PCX, state.PCY, state.Stack))
+}
+
+func (o *recordingObserver) AfterStep(state ProcState, op rune) {
+	o.events = append(o.events, fmt.Sprintf("after %c (%d, %d) %v", op, state.PCX, state.PCY, state.Stack))
+}
+
+func (o *recordingObserver) OnPut(x, y int, old, val rune) {
+	o.events = append(o.events, fmt.Sprintf("put (%d, %d) %q %q", x, y, old, val))
+}
+
+func (o *recordingObserver) OnOutput(b []byte) {
+	o.events = append(o.events, fmt.Sprintf("output %q", b))
+}
+
+func (o *recordingObserver) OnInput(val int64) {
+	o.events = append(o.events, fmt.Sprintf("input %d", val))
+}
+
+func Test_Observer(t *testing.T) {
+	prog, err := NewProg(`~"x"10p.@`, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	obs := &recordingObserver{}
+	proc := NewProc(prog, strings.NewReader("a"), &bytes.Buffer{}, &bytes.Buffer{}, WithObserver(obs))
+	err = proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expected := []string{
+		"before (0, 0) []",
+		"input 97",
+		"after ~ (1, 0) [97]",
+		"before (1, 0) [97]",
+		`after " (2, 0) [97]`,
+		"before (2, 0) [97]",
+		"after x (3, 0) [97 120]",
+		"before (3, 0) [97 120]",
+		`after " (4, 0) [97 120]`,
+		"before (4, 0) [97 120]",
+		"after 1 (5, 0) [97 120 1]",
+		"before (5, 0) [97 120 1]",
+		"after 0 (6, 0) [97 120 1 0]",
+		"before (6, 0) [97 120 1 0]",
+		"put (1, 0) '\"' 'x'",
+		"after p (7, 0) [97]",
+		"before (7, 0) [97]",
+		`output "97 "`,
+		"after . (8, 0) []",
+		"before (8, 0) []",
+		"after @ (8, 0) []",
+	}
+
+	if strings.Join(obs.events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("should be equal:\n%s", strings.Join(obs.events, "\n"))
+	}
+}
+
+type outputCounter struct {
+	NopObserver
+	n int
+}
+
+func (c *outputCounter) OnOutput([]byte) { c.n++ }
+
+func Test_NopObserver(t *testing.T) {
+	prog, err := NewProg(`12+.@`, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	c := &outputCounter{}
+	proc := NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}, WithObserver(c))
+	err = proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if c.n != 1 {
+		t.Fatal("should be equal")
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func Test_Observer_OutputFailed(t *testing.T) {
+	prog, err := NewProg(`1.@`, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	c := &outputCounter{}
+	proc := NewProc(prog, &bytes.Buffer{}, failingWriter{}, &bytes.Buffer{}, WithObserver(c))
+	err = proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if c.n != 0 {
+		t.Fatal("should not be notified of failed output")
+	}
+}
@@ -1,15 +1,34 @@
 package bef93
 
-type direction uint8
+import "fmt"
 
+// Direction is the direction the PC moves into.
+type Direction uint8
+
+// Possible directions.
 const (
-	dirRight direction = iota
-	dirDown
-	dirLeft
-	dirUp
+	DirRight Direction = iota
+	DirDown
+	DirLeft
+	DirUp
 	dirEND
 )
 
+func (d Direction) String() string {
+	switch d {
+	case DirRight:
+		return "right"
+	case DirDown:
+		return "down"
+	case DirLeft:
+		return "left"
+	case DirUp:
+		return "up"
+	default:
+		return "unknown"
+	}
+}
+
 type opcode rune
 
 // https://github.com/catseye/Befunge-93/blob/master/doc/Befunge-93.markdown#appendix-a-command-summary
@@ -43,3 +62,265 @@ const (
 	opEnd        opcode = '@'  // End program
 	opWhitespace opcode = ' '
 )
+
+// opHandler executes the instruction op, without advancing the PC.
+// String mode is handled by the caller.
+type opHandler func(p *Proc, op opcode) error
+
+// lookupOp returns the handler for op, used by all execution engines.
+func lookupOp(op opcode) opHandler {
+	if op >= '0' && op <= '9' {
+		return execDigit
+	}
+
+	switch op {
+	case opAdd:
+		return execAdd
+	case opSub:
+		return execSub
+	case opMul:
+		return execMul
+	case opDiv:
+		return execDiv
+	case opMod:
+		return execMod
+	case opNot:
+		return execNot
+	case opGt:
+		return execGt
+	case opRight:
+		return execRight
+	case opLeft:
+		return execLeft
+	case opUp:
+		return execUp
+	case opDown:
+		return execDown
+	case opRand:
+		return execRand
+	case opRif:
+		return execRif
+	case opDif:
+		return execDif
+	case opStr:
+		return execStr
+	case opDup:
+		return execDup
+	case opSwp:
+		return execSwp
+	case opPop:
+		return execPop
+	case opPopWrtInt:
+		return execPopWrtInt
+	case opPopWrtChr:
+		return execPopWrtChr
+	case opSkip:
+		return execSkip
+	case opPut:
+		return execPut
+	case opGet:
+		return execGet
+	case opReadNr:
+		return execReadNr
+	case opReadChr:
+		return execReadChr
+	case opEnd:
+		return execEnd
+	case opWhitespace:
+		return execNop
+	}
+	return execUnknown
+}
+
+func execDigit(p *Proc, op opcode) error {
+	p.stack.push(int64(op - '0'))
+	return nil
+}
+
+func execAdd(p *Proc, _ opcode) error {
+	a, b := p.stack.pop2()
+	p.stack.push(a + b)
+	return nil
+}
+
+func execSub(p *Proc, _ opcode) error {
+	a, b := p.stack.pop2()
+	p.stack.push(b - a)
+	return nil
+}
+
+func execMul(p *Proc, _ opcode) error {
+	a, b := p.stack.pop2()
+	p.stack.push(a * b)
+	return nil
+}
+
+func execDiv(p *Proc, _ opcode) error {
+	a, b := p.stack.pop2()
+	if a == 0 {
+		return p.divZero(b)
+	}
+	p.stack.push(b / a)
+	return nil
+}
+
+func execMod(p *Proc, _ opcode) error {
+	a, b := p.stack.pop2()
+	if a == 0 {
+		return p.modZero(b)
+	}
+	p.stack.push(b % a)
+	return nil
+}
+
+func execRand(p *Proc, _ opcode) error {
+	dir, err := p.randDir()
+	if err != nil {
+		return err
+	}
+	p.dir = dir
+	return nil
+}
+
+func execPopWrtInt(p *Proc, _ opcode) error {
+	return p.output([]byte(fmt.Sprintf("%d ", p.stack.pop())))
+}
+
+func execPopWrtChr(p *Proc, _ opcode) error {
+	c := rune(p.stack.pop())
+	if !p.prog.opts.AllowUnicode {
+		c = rune(byte(c))
+	}
+	return p.output([]byte(string([]rune{c})))
+}
+
+func execReadNr(p *Proc, _ opcode) error {
+	val, err := p.input(p.readNr)
+	if err != nil {
+		return err
+	}
+	p.pushInput(val)
+	return nil
+}
+
+func execReadChr(p *Proc, _ opcode) error {
+	val, err := p.input(p.readChr)
+	if err != nil {
+		return err
+	}
+	p.pushInput(val)
+	return nil
+}
+
+func execPut(p *Proc, _ opcode) error {
+	return p.put()
+}
+
+func execGet(p *Proc, _ opcode) error {
+	return p.get()
+}
+
+func execNot(p *Proc, _ opcode) error {
+	if p.stack.pop() == 0 {
+		p.stack.push(1)
+	} else {
+		p.stack.push(0)
+	}
+	return nil
+}
+
+func execGt(p *Proc, _ opcode) error {
+	a, b := p.stack.pop2()
+	if b > a {
+		p.stack.push(1)
+	} else {
+		p.stack.push(0)
+	}
+	return nil
+}
+
+func execRight(p *Proc, _ opcode) error {
+	p.dir = DirRight
+	return nil
+}
+
+func execLeft(p *Proc, _ opcode) error {
+	p.dir = DirLeft
+	return nil
+}
+
+func execUp(p *Proc, _ opcode) error {
+	p.dir = DirUp
+	return nil
+}
+
+func execDown(p *Proc, _ opcode) error {
+	p.dir = DirDown
+	return nil
+}
+
+func execRif(p *Proc, _ opcode) error {
+	if p.stack.pop() == 0 {
+		p.dir = DirRight
+	} else {
+		p.dir = DirLeft
+	}
+	return nil
+}
+
+func execDif(p *Proc, _ opcode) error {
+	if p.stack.pop() == 0 {
+		p.dir = DirDown
+	} else {
+		p.dir = DirUp
+	}
+	return nil
+}
+
+func execStr(p *Proc, _ opcode) error {
+	p.strMode = !p.strMode
+	return nil
+}
+
+func execDup(p *Proc, _ opcode) error {
+	a := p.stack.pop()
+	p.stack.push(a)
+	p.stack.push(a)
+	return nil
+}
+
+func execSwp(p *Proc, _ opcode) error {
+	a, b := p.stack.pop2()
+	p.stack.push(a)
+	p.stack.push(b)
+	return nil
+}
+
+func execPop(p *Proc, _ opcode) error {
+	_ = p.stack.pop()
+	return nil
+}
+
+func execSkip(p *Proc, _ opcode) error {
+	if p.prog.opts.WrapHashInconsistently {
+		p.movePC()
+	} else {
+		p.advancePC()
+	}
+	return nil
+}
+
+func execEnd(*Proc, opcode) error {
+	return errTerminated
+}
+
+func execNop(*Proc, opcode) error {
+	return nil
+}
+
+func execUnknown(p *Proc, op opcode) error {
+	if p.prog.opts.IgnoreUnsupportedInstructions {
+		return nil
+	}
+	return p.newRuntimeError(fmt.Errorf("%w: '%s' (%d)", ErrUnknownOpCode, string(op), int64(op)))
+}
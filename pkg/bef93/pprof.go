@@ -0,0 +1,177 @@
+package bef93
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Field numbers of the pprof profile.proto messages,
+// see https://github.com/google/pprof/blob/main/proto/profile.proto.
+const (
+	pprofProfileSampleType  = 1
+	pprofProfileSample      = 2
+	pprofProfileLocation    = 4
+	pprofProfileFunction    = 5
+	pprofProfileStringTable = 6
+	pprofProfilePeriodType  = 11
+	pprofProfilePeriod      = 12
+
+	pprofValueTypeType = 1
+	pprofValueTypeUnit = 2
+
+	pprofSampleLocationID = 1
+	pprofSampleValue      = 2
+
+	pprofLocationID   = 1
+	pprofLocationLine = 4
+
+	pprofLineFunctionID = 1
+	pprofLineLine       = 2
+	pprofLineColumn     = 3
+
+	pprofFunctionID         = 1
+	pprofFunctionName       = 2
+	pprofFunctionSystemName = 3
+	pprofFunctionFilename   = 4
+	pprofFunctionStartLine  = 5
+)
+
+// protoBuf is a minimal protocol buffers encoder.
+type protoBuf struct {
+	b []byte
+}
+
+func (b *protoBuf) varint(v uint64) {
+	for v >= 0x80 {
+		b.b = append(b.b, byte(v)|0x80)
+		v >>= 7
+	}
+	b.b = append(b.b, byte(v))
+}
+
+func (b *protoBuf) tag(field, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// int64 writes a varint field, omitting zero values.
+func (b *protoBuf) int64(field int, v int64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, 0)
+	b.varint(uint64(v))
+}
+
+// packed writes a packed repeated varint field.
+func (b *protoBuf) packed(field int, vals ...int64) {
+	inner := protoBuf{}
+	for _, v := range vals {
+		inner.varint(uint64(v))
+	}
+	b.bytes(field, inner.b)
+}
+
+func (b *protoBuf) bytes(field int, v []byte) {
+	b.tag(field, 2)
+	b.varint(uint64(len(v)))
+	b.b = append(b.b, v...)
+}
+
+func (b *protoBuf) msg(field int, m protoBuf) {
+	b.bytes(field, m.b)
+}
+
+// cellOp is an instruction executed at a cell.
+type cellOp struct {
+	x, y int
+	op   rune
+}
+
+// WritePprof writes the execution counts per cell in the gzip compressed
+// protocol buffers format of pprof, so it can be inspected using "go tool pprof".
+// Each cell and instruction executed at that cell is a function, named like "3,0 '+'".
+// Lines and columns of the functions are the 1-based cell coordinates in fileName.
+// Samples count the executed instructions.
+func (p *Profiler) WritePprof(w io.Writer, fileName string) error {
+	table := []string{""}
+	stringIdx := map[string]int64{"": 0}
+	str := func(s string) int64 {
+		if i, ok := stringIdx[s]; ok {
+			return i
+		}
+		stringIdx[s] = int64(len(table))
+		table = append(table, s)
+		return stringIdx[s]
+	}
+
+	keys := make([]cellOp, 0, len(p.cellOps))
+	for k := range p.cellOps {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.y != b.y {
+			return a.y < b.y
+		}
+		if a.x != b.x {
+			return a.x < b.x
+		}
+		return a.op < b.op
+	})
+
+	prof := protoBuf{}
+
+	valueType := protoBuf{}
+	valueType.int64(pprofValueTypeType, str("instructions"))
+	valueType.int64(pprofValueTypeUnit, str("count"))
+	prof.msg(pprofProfileSampleType, valueType)
+
+	fileIdx := str(fileName)
+	for i, k := range keys {
+		// IDs must not be zero
+		id := int64(i + 1)
+
+		sample := protoBuf{}
+		sample.packed(pprofSampleLocationID, id)
+		sample.packed(pprofSampleValue, p.cellOps[k])
+		prof.msg(pprofProfileSample, sample)
+
+		line := protoBuf{}
+		line.int64(pprofLineFunctionID, id)
+		line.int64(pprofLineLine, int64(k.y+1))
+		line.int64(pprofLineColumn, int64(k.x+1))
+		loc := protoBuf{}
+		loc.int64(pprofLocationID, id)
+		loc.msg(pprofLocationLine, line)
+		prof.msg(pprofProfileLocation, loc)
+
+		name := str(fmt.Sprintf("%d,%d %s", k.x, k.y, strconv.QuoteRune(k.op)))
+		fn := protoBuf{}
+		fn.int64(pprofFunctionID, id)
+		fn.int64(pprofFunctionName, name)
+		fn.int64(pprofFunctionSystemName, name)
+		fn.int64(pprofFunctionFilename, fileIdx)
+		fn.int64(pprofFunctionStartLine, int64(k.y+1))
+		prof.msg(pprofProfileFunction, fn)
+	}
+
+	// string table must be complete before it is written
+	periodType := protoBuf{}
+	periodType.int64(pprofValueTypeType, str("instructions"))
+	periodType.int64(pprofValueTypeUnit, str("count"))
+	for _, s := range table {
+		prof.bytes(pprofProfileStringTable, []byte(s))
+	}
+	prof.msg(pprofProfilePeriodType, periodType)
+	prof.int64(pprofProfilePeriod, 1)
+
+	gw := gzip.NewWriter(w)
+	_, err := gw.Write(prof.b)
+	if cerr := gw.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
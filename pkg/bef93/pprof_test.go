@@ -0,0 +1,120 @@
+package bef93
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+type protoField struct {
+	num int
+	val uint64
+	b   []byte
+}
+
+func readVarint(t *testing.T, b []byte) (uint64, []byte) {
+	v := uint64(0)
+	for i, c := range b {
+		v |= uint64(c&0x7f) << (7 * i)
+		if c < 0x80 {
+			return v, b[i+1:]
+		}
+	}
+	t.Fatal("truncated varint")
+	return 0, nil
+}
+
+// parseProto parses varint and length delimited fields.
+func parseProto(t *testing.T, b []byte) []protoField {
+	ret := []protoField{}
+	for len(b) > 0 {
+		var tag uint64
+		tag, b = readVarint(t, b)
+		f := protoField{num: int(tag >> 3)}
+
+		switch tag & 7 {
+		case 0:
+			f.val, b = readVarint(t, b)
+		case 2:
+			var l uint64
+			l, b = readVarint(t, b)
+			f.b, b = b[:l], b[l:]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+		ret = append(ret, f)
+	}
+	return ret
+}
+
+func Test_Profiler_WritePprof(t *testing.T) {
+	prog, err := NewProg(`"a"v
+ @,<`, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	prof := NewProfiler()
+	err = NewProc(prog, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}, WithObserver(prof)).Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	b := &bytes.Buffer{}
+	err = prof.WritePprof(b, "test.bf")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	gr, err := gzip.NewReader(b)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	raw, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	table := []string{}
+	samples, locations, functions := 0, 0, 0
+	total := uint64(0)
+	for _, f := range parseProto(t, raw) {
+		switch f.num {
+		case pprofProfileSample:
+			samples++
+			for _, sf := range parseProto(t, f.b) {
+				if sf.num == pprofSampleValue {
+					v, _ := readVarint(t, sf.b)
+					total += v
+				}
+			}
+		case pprofProfileLocation:
+			locations++
+		case pprofProfileFunction:
+			functions++
+		case pprofProfileStringTable:
+			table = append(table, string(f.b))
+		}
+	}
+
+	if samples != 7 || locations != 7 || functions != 7 {
+		t.Fatalf("invalid counts %d %d %d", samples, locations, functions)
+	}
+	if total != uint64(prof.Total()) {
+		t.Fatal("should be equal")
+	}
+	if len(table) == 0 || table[0] != "" {
+		t.Fatal("first string must be empty")
+	}
+	for _, s := range []string{"instructions", "count", "test.bf", "0,0 '\"'", "1,0 'a'", "1,1 '@'"} {
+		found := false
+		for _, ts := range table {
+			found = found || ts == s
+		}
+		if !found {
+			t.Fatalf("string table should contain %q: %q", s, table)
+		}
+	}
+}
@@ -2,8 +2,8 @@ package bef93
 
 import (
 	"bufio"
+	"context"
 	"io"
-	"math/rand"
 	"sync"
 	"time"
 )
@@ -17,36 +17,86 @@ type Proc struct {
 	in          *bufio.Reader
 	out, outErr io.Writer
 
-	rand *rand.Rand
+	rand RandSource
 
-	dir      direction
+	// set while running inside ExecContext()
+	ctx context.Context
+	// closed when a read abandoned by ExecContext() finishes, see wait()
+	abandonedRead chan struct{}
+
+	observers []Observer
+
+	breakpoints []*Breakpoint
+	resumeSteps int64 // steps+1 of the location where the last breakpoint was hit
+	watchHit    error // set if a watchpoint was hit during the current step
+
+	journal *journal // nil if disabled
+	redo    []Event  // events to replay after StepBack(), last one first
+
+	recording *ReplayLog // nil if disabled
+	replay    *replayer  // nil if disabled
+
+	compiled *compiledProg // nil if the default engine is used
+
+	dir      Direction
 	pcX, pcY int
 	strMode  bool
 	stack    stack
 	done     bool
+	steps    int64
 
 	//lint:ignore U1000 ignore unused copy guard
 	noCopy sync.Mutex
 }
 
+// ProcOption configures optional features of a Proc.
+type ProcOption func(*Proc)
+
 // NewProc creates a new Proc.
 // In is the new procs stdin, out stdout, outErr stderr.
-func NewProc(prog *Prog, in io.Reader, out, outErr io.Writer) *Proc {
+func NewProc(prog *Prog, in io.Reader, out, outErr io.Writer, opts ...ProcOption) *Proc {
 	seed := time.Now().UnixNano()
 	if prog.opts.RandSeed != 0 {
 		seed = prog.opts.RandSeed
 	}
 
-	return &Proc{
+	p := &Proc{
 		prog: prog.Clone(),
 
-		// #nosec G404 We want to be deterministic here.
-		rand: rand.New(rand.NewSource(seed)),
+		rand: newSeededRand(seed, 0),
 
 		in:     bufio.NewReader(in),
 		out:    out,
 		outErr: outErr,
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// ProcState is a snapshot of the state of a Proc.
+type ProcState struct {
+	PCX, PCY int       // location of the next instruction
+	Dir      Direction // direction of the PC
+	StrMode  bool      // whether string mode is active
+	Stack    []int64   // values on the stack, bottom first
+	Steps    int64     // number of instructions executed
+	Done     bool      // whether the proc has terminated
+}
+
+// State returns a snapshot of the current state of the proc.
+func (p *Proc) State() ProcState {
+	return ProcState{
+		PCX:     p.pcX,
+		PCY:     p.pcY,
+		Dir:     p.dir,
+		StrMode: p.strMode,
+		Stack:   p.stack.values(),
+		Steps:   p.steps,
+		Done:    p.done,
+	}
 }
 
 // Prog returns a copy of the current Prog inside the proc.
@@ -55,10 +105,26 @@ func (p *Proc) Prog() *Prog {
 	return &prog
 }
 
+// PeekInput returns up to n bytes of pending input, without consuming them.
+// Blocks until n bytes are available or the input is exhausted.
+// If ExecContext() was cancelled while blocked on reading input,
+// first waits for that read to finish, see ExecContext().
+func (p *Proc) PeekInput(n int) []byte {
+	if p.abandonedRead != nil {
+		<-p.abandonedRead
+	}
+	b, _ := p.in.Peek(n)
+	return append([]byte(nil), b...)
+}
+
 // Clone returns a pointer to a deep copy of a proc.
 // You need to supply new I/O pipes.
-func (p *Proc) Clone(in io.Reader, out, outErr io.Writer) *Proc {
-	return &Proc{
+// Options and breakpoints are not copied, options can be supplied again.
+// The random source continues with the same sequence in the clone if it
+// implements RandCloner (the default source does), otherwise it is shared.
+// Cloning the default source replays the random values drawn so far.
+func (p *Proc) Clone(in io.Reader, out, outErr io.Writer, opts ...ProcOption) *Proc {
+	c := &Proc{
 		prog: p.prog.Clone(),
 
 		in:     bufio.NewReader(in),
@@ -71,5 +137,20 @@ func (p *Proc) Clone(in io.Reader, out, outErr io.Writer) *Proc {
 		strMode: p.strMode,
 		stack:   p.stack.clone(),
 		done:    p.done,
+		steps:   p.steps,
+
+		rand: cloneRand(p.rand),
+
+		redo: append([]Event(nil), p.redo...),
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+
+	return c
+}
+
+// Steps returns the number of instructions executed so far.
+func (p *Proc) Steps() int64 {
+	return p.steps
 }
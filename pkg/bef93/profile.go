@@ -0,0 +1,159 @@
+package bef93
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Profiler is an Observer counting how often each cell and each instruction
+// is executed. Attach it to a Proc using WithObserver().
+// Instructions which fail with an error are not counted.
+type Profiler struct {
+	NopObserver
+
+	cells   [][]int64 // execution counts, indexed by y, x
+	lastOps [][]rune  // last executed instruction, indexed by y, x
+	cellOps map[cellOp]int64
+	ops     map[rune]int64
+	strMode int64
+	total   int64
+
+	// location and string mode of the current instruction
+	x, y        int
+	strModeStep bool
+}
+
+// ProfileCell is the execution count of a single cell, see Profiler.Cells().
+type ProfileCell struct {
+	X, Y int
+	// Op is the instruction at the cell when it was last executed.
+	Op    rune
+	Count int64
+}
+
+// ProfileOp is the execution count of an instruction, see Profiler.Ops().
+type ProfileOp struct {
+	Op    rune
+	Count int64
+}
+
+// NewProfiler creates a new Profiler.
+func NewProfiler() *Profiler {
+	return &Profiler{cellOps: map[cellOp]int64{}, ops: map[rune]int64{}}
+}
+
+// BeforeStep implements Observer.
+func (p *Profiler) BeforeStep(state ProcState) {
+	p.x, p.y = state.PCX, state.PCY
+	p.strModeStep = state.StrMode
+}
+
+// AfterStep implements Observer.
+func (p *Profiler) AfterStep(_ ProcState, op rune) {
+	for len(p.cells) <= p.y {
+		p.cells = append(p.cells, nil)
+		p.lastOps = append(p.lastOps, nil)
+	}
+	if len(p.cells[p.y]) <= p.x {
+		p.cells[p.y] = append(p.cells[p.y], make([]int64, p.x+1-len(p.cells[p.y]))...)
+		p.lastOps[p.y] = append(p.lastOps[p.y], make([]rune, p.x+1-len(p.lastOps[p.y]))...)
+	}
+
+	p.cells[p.y][p.x]++
+	p.lastOps[p.y][p.x] = op
+	p.cellOps[cellOp{x: p.x, y: p.y, op: op}]++
+	p.total++
+
+	if p.strModeStep && op != rune(opStr) {
+		p.strMode++
+	} else {
+		p.ops[op]++
+	}
+}
+
+// Total returns the total number of executed instructions.
+func (p *Profiler) Total() int64 {
+	return p.total
+}
+
+// CellCount returns how often the cell at (x, y) was executed.
+func (p *Profiler) CellCount(x, y int) int64 {
+	if y < 0 || y >= len(p.cells) || x < 0 || x >= len(p.cells[y]) {
+		return 0
+	}
+	return p.cells[y][x]
+}
+
+// MaxCellCount returns the highest execution count of any cell.
+func (p *Profiler) MaxCellCount() int64 {
+	ret := int64(0)
+	for _, row := range p.cells {
+		for _, c := range row {
+			ret = max(ret, c)
+		}
+	}
+	return ret
+}
+
+// Cells returns all executed cells, ordered by location (row by row).
+func (p *Profiler) Cells() []ProfileCell {
+	ret := []ProfileCell{}
+	for y, row := range p.cells {
+		for x, c := range row {
+			if c > 0 {
+				ret = append(ret, ProfileCell{X: x, Y: y, Op: p.lastOps[y][x], Count: c})
+			}
+		}
+	}
+	return ret
+}
+
+// Ops returns how often each instruction was executed, ordered by count, highest first.
+// Characters pushed in string mode are not included, see StrModeCount().
+func (p *Profiler) Ops() []ProfileOp {
+	ret := make([]ProfileOp, 0, len(p.ops))
+	for op, c := range p.ops {
+		ret = append(ret, ProfileOp{Op: op, Count: c})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Count != ret[j].Count {
+			return ret[i].Count > ret[j].Count
+		}
+		return ret[i].Op < ret[j].Op
+	})
+	return ret
+}
+
+// StrModeCount returns the number of characters pushed in string mode.
+func (p *Profiler) StrModeCount() int64 {
+	return p.strMode
+}
+
+// WriteCellsCSV writes the execution counts of all executed cells as CSV,
+// with the columns x, y, op and count.
+func (p *Profiler) WriteCellsCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"x", "y", "op", "count"})
+	for _, c := range p.Cells() {
+		_ = cw.Write([]string{strconv.Itoa(c.X), strconv.Itoa(c.Y), string(c.Op), strconv.FormatInt(c.Count, 10)})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteOpsCSV writes the execution counts of all instructions as CSV,
+// with the columns op and count, ordered by count, highest first.
+func (p *Profiler) WriteOpsCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"op", "count"})
+	for _, o := range p.Ops() {
+		_ = cw.Write([]string{string(o.Op), strconv.FormatInt(o.Count, 10)})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,87 @@
+package bef93
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func Test_Profiler(t *testing.T) {
+	// counts down from 3
+	prog, err := NewProg(`3>1-:v
+ ^   _"x"@`, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	prof := NewProfiler()
+	proc := NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}, WithObserver(prof))
+	err = proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if prof.Total() != proc.Steps() {
+		t.Fatal("should be equal")
+	}
+	if prof.CellCount(0, 0) != 1 || prof.CellCount(2, 0) != 3 || prof.CellCount(1, 1) != 2 || prof.CellCount(0, 1) != 0 {
+		t.Fatal("should be equal")
+	}
+	if prof.CellCount(-1, 0) != 0 || prof.CellCount(100, 100) != 0 {
+		t.Fatal("should be 0")
+	}
+	if prof.MaxCellCount() != 3 {
+		t.Fatal("should be equal")
+	}
+	if prof.StrModeCount() != 1 {
+		t.Fatal("should be equal")
+	}
+
+	ops := prof.Ops()
+	if !reflect.DeepEqual(ops[:3], []ProfileOp{{' ', 6}, {'-', 3}, {'1', 3}}) {
+		t.Fatalf("invalid ops %v", ops)
+	}
+	sum := prof.StrModeCount()
+	for _, o := range ops {
+		sum += o.Count
+	}
+	if sum != prof.Total() {
+		t.Fatal("should be equal")
+	}
+
+	cells := prof.Cells()
+	if cells[0] != (ProfileCell{X: 0, Y: 0, Op: '3', Count: 1}) {
+		t.Fatalf("invalid cell %v", cells[0])
+	}
+}
+
+func Test_Profiler_CSV(t *testing.T) {
+	prog, err := NewProg(`"a"@`, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	prof := NewProfiler()
+	err = NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}, WithObserver(prof)).Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	b := &bytes.Buffer{}
+	err = prof.WriteCellsCSV(b)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if b.String() != "x,y,op,count\n0,0,\"\"\"\",1\n1,0,a,1\n2,0,\"\"\"\",1\n3,0,@,1\n" {
+		t.Fatalf("should be equal:\n%s", b.String())
+	}
+
+	b.Reset()
+	err = prof.WriteOpsCSV(b)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if b.String() != "op,count\n\"\"\"\",2\n@,1\n" {
+		t.Fatalf("should be equal:\n%s", b.String())
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"sync"
 	"unicode"
+	"unicode/utf8"
 )
 
 // default program size
@@ -22,7 +23,9 @@ const (
 type Opts struct {
 	// Options from the standard/reference implementation.
 
-	// TODO: implement.
+	// If true, 'p' and 'g' accept coordinates one past the right and bottom
+	// edge, like old versions of the reference implementation did.
+	// An x equal to the width addresses the first cell of the next row.
 	NoFixOffByOne bool
 	// If true, & will push an undefined number to stack instead of -1.
 	ReadErrorUndefined bool
@@ -31,9 +34,14 @@ type Opts struct {
 	// This is unlike the reference implementation, which will just print an error
 	// and continue.
 	IgnoreUnsupportedInstructions bool
-	// TODO: implement.
+	// If true, lines longer than the standard width are wrapped onto the next
+	// row instead of being rejected.
+	// Like in the reference implementation, rows past the standard height are
+	// dropped, unless AllowArbitraryCodeSize is set.
 	WrapLongLines bool
-	// TODO: implement.
+	// If true, '#' at the edge of the playfield wraps like in the reference
+	// implementation: when moving left or up from the first column or row,
+	// it lands on the last column or row instead of skipping it.
 	WrapHashInconsistently bool
 
 	// Our custom additional options.
@@ -51,11 +59,24 @@ type Opts struct {
 	// is seeded randomly internally.
 	// This allows to deterministically execute programs containing
 	// random operations.
+	// Not used if a random source is given using WithRandSource().
 	RandSeed int64
 	// Terminate on I/O errors instead of ignoring them.
+	// The returned RuntimeError wraps ErrIO.
 	TerminateOnIOErr bool
 	// Terminate if a 'g' or 'p' operation is out of bounds, instead of pushing 0 or discading the pop() value.
 	TerminateOnPutGetOutOfBounds bool
+	// Maximum number of instructions to execute before terminating.
+	// If 0, there is no limit.
+	MaxSteps int64
+	// Maximum number of values on the stack before terminating.
+	// If 0, there is no limit.
+	MaxStackDepth int
+	// Maximum number of cells (width * height) of the program grid,
+	// checked before the grid is allocated.
+	// Only useful together with AllowArbitraryCodeSize.
+	// If 0, there is no limit.
+	MaxCodeCells int
 }
 
 // Prog represents a Befunge-93 program.
@@ -87,7 +108,7 @@ func getMaxSize(lines []string) (w, h int) {
 	h = len(lines)
 
 	for _, line := range lines {
-		l := len(line)
+		l := utf8.RuneCountInString(line)
 		if l > w {
 			w = l
 		}
@@ -96,32 +117,74 @@ func getMaxSize(lines []string) (w, h int) {
 	return
 }
 
+// wrapLines splits lines longer than w runes into multiple lines.
+func wrapLines(lines []string, w int) []string {
+	ret := make([]string, 0, len(lines))
+
+	for _, l := range lines {
+		runes := []rune(l)
+		for len(runes) > w {
+			ret = append(ret, string(runes[:w]))
+			runes = runes[w:]
+		}
+		ret = append(ret, string(runes))
+	}
+
+	return ret
+}
+
 // Common errors returned by NewProg().
 // Will be wrapped in a CompilationError, so use errors.Is/As().
 var (
-	ErrNotASCII = errors.New("code contains non-ascii characters")
-	ErrTooLarge = errors.New("program code is too large")
+	ErrNotASCII        = errors.New("code contains non-ascii characters")
+	ErrTooLarge        = errors.New("program code is too large")
+	ErrUnsupportedOpts = errors.New("unsupported option combination")
 )
 
+func validateOpts(opts Opts) error {
+	if opts.MaxSteps < 0 {
+		return fmt.Errorf("%w: MaxSteps must not be negative", ErrUnsupportedOpts)
+	}
+	if opts.MaxStackDepth < 0 {
+		return fmt.Errorf("%w: MaxStackDepth must not be negative", ErrUnsupportedOpts)
+	}
+	if opts.MaxCodeCells < 0 {
+		return fmt.Errorf("%w: MaxCodeCells must not be negative", ErrUnsupportedOpts)
+	}
+	if opts.MaxCodeCells > 0 && opts.MaxCodeCells < Width*Height {
+		return fmt.Errorf("%w: MaxCodeCells must be at least %d", ErrUnsupportedOpts, Width*Height)
+	}
+
+	return nil
+}
+
 // NewProg creates a new program from source code and options.
 func NewProg(code string, opts Opts) (*Prog, error) {
+	if err := validateOpts(opts); err != nil {
+		return nil, newCompilationError(err, 0, 0, nil)
+	}
+
 	lines := strings.Split(code, "\n")
 
 	if !opts.AllowUnicode {
 		ok, x, y := isASCII(lines)
 		if !ok {
-			return nil, newCompilationError(ErrNotASCII, x, y)
+			return nil, newCompilationError(ErrNotASCII, x, y, lines)
 		}
 	}
 
-	w, h := getMaxSize(lines)
-	if !opts.AllowArbitraryCodeSize && (w > Width || h > Height) {
-		return nil, newCompilationError(ErrTooLarge, w, h)
+	if opts.WrapLongLines {
+		lines = wrapLines(lines, Width)
+		if !opts.AllowArbitraryCodeSize && len(lines) > Height {
+			lines = lines[:Height]
+		}
 	}
 
+	w, h := getMaxSize(lines)
 	if !opts.AllowArbitraryCodeSize && (w > Width || h > Height) {
-		return nil, newCompilationError(ErrTooLarge, w, h)
+		return nil, newCompilationError(ErrTooLarge, w, h, lines)
 	}
+
 	if w < Width {
 		w = Width
 	}
@@ -129,6 +192,11 @@ func NewProg(code string, opts Opts) (*Prog, error) {
 		h = Height
 	}
 
+	if opts.MaxCodeCells > 0 && w*h > opts.MaxCodeCells {
+		err := fmt.Errorf("%w: %d cells, limit is %d", ErrTooLarge, w*h, opts.MaxCodeCells)
+		return nil, newCompilationError(err, w, h, lines)
+	}
+
 	// pad rows
 	for len(lines) < h {
 		lines = append(lines, "")
@@ -136,7 +204,7 @@ func NewProg(code string, opts Opts) (*Prog, error) {
 
 	// pad cols
 	for i, l := range lines {
-		lines[i] = l + strings.Repeat(" ", w-len(l))
+		lines[i] = l + strings.Repeat(" ", w-utf8.RuneCountInString(l))
 	}
 
 	runes := make([][]rune, h)
@@ -144,16 +212,6 @@ func NewProg(code string, opts Opts) (*Prog, error) {
 		runes[i] = []rune(l)
 	}
 
-	if opts.NoFixOffByOne {
-		panic("option NoFixOffByOne: not implemented")
-	}
-	if opts.WrapLongLines {
-		panic("option WrapLongLines: not implemented")
-	}
-	if opts.WrapHashInconsistently {
-		panic("option WrapHashInconsistently: not implemented")
-	}
-
 	return &Prog{
 		code: runes,
 		w:    w,
@@ -204,6 +262,20 @@ func (p *Prog) Code() string {
 	return strings.TrimSpace(ret.String())
 }
 
+// Size returns the width and height of this program.
+func (p *Prog) Size() (w, h int) {
+	return p.w, p.h
+}
+
+// Cell returns the content of the cell at (x, y).
+// Returns 0 if out of bounds.
+func (p *Prog) Cell(x, y int) rune {
+	if x < 0 || x >= p.w || y < 0 || y >= p.h {
+		return 0
+	}
+	return p.code[y][x]
+}
+
 // Opts returns the options of this program.
 func (p *Prog) Opts() Opts {
 	return p.opts
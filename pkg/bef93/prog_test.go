@@ -2,6 +2,7 @@ package bef93
 
 import (
 	"errors"
+	"strings"
 	"testing"
 )
 
@@ -138,3 +139,126 @@ v,,,,,,"World!"<
 		t.Fatal("structs are equal")
 	}
 }
+
+func Test_NewProg_MaxCodeCells(t *testing.T) {
+	code := strings.Repeat("#", 100) + "\n@"
+
+	_, err := NewProg(code, Opts{AllowArbitraryCodeSize: true, MaxCodeCells: 100 * Height})
+	if err != nil {
+		t.Fatalf("err is not is nil: %s", err)
+	}
+
+	_, err = NewProg(code, Opts{AllowArbitraryCodeSize: true, MaxCodeCells: 99 * Height})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("should fail")
+	}
+
+	var cerr *CompilationError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("should be a CompilationError")
+	}
+}
+
+func Test_NewProg_WrapLongLines(t *testing.T) {
+	code := strings.Repeat("1", Width) + strings.Repeat("2", Width) + "3\n4"
+
+	_, err := NewProg(code, Opts{})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("should fail")
+	}
+
+	prog, err := NewProg(code, Opts{WrapLongLines: true})
+	if err != nil {
+		t.Fatalf("err is not is nil: %s", err)
+	}
+
+	if prog.w != Width || prog.h != Height {
+		t.Fatalf("invalid size %dx%d", prog.w, prog.h)
+	}
+
+	expected := strings.Repeat("1", Width) + "\n" + strings.Repeat("2", Width) + "\n3\n4"
+	if prog.Code() != expected {
+		t.Fatal("code is not equal")
+	}
+
+	// rows past the standard height are dropped
+	prog, err = NewProg(strings.Repeat("1", Width*Height)+"2\n3", Opts{WrapLongLines: true})
+	if err != nil {
+		t.Fatalf("err is not is nil: %s", err)
+	}
+	if prog.h != Height || strings.ContainsAny(prog.Code(), "23") {
+		t.Fatal("rows should be dropped")
+	}
+
+	prog, err = NewProg(strings.Repeat("1", Width*Height)+"2", Opts{WrapLongLines: true, AllowArbitraryCodeSize: true})
+	if err != nil {
+		t.Fatalf("err is not is nil: %s", err)
+	}
+	if prog.h != Height+1 {
+		t.Fatalf("invalid height %d", prog.h)
+	}
+}
+
+func Test_NewProg_UnicodeWidth(t *testing.T) {
+	code := strings.Repeat("ö", Width)
+
+	prog, err := NewProg(code+"\n@", Opts{AllowUnicode: true})
+	if err != nil {
+		t.Fatalf("err is not is nil: %s", err)
+	}
+	if prog.w != Width {
+		t.Fatalf("invalid width %d", prog.w)
+	}
+	for _, l := range prog.code {
+		if len(l) != Width {
+			t.Fatalf("invalid line length %d", len(l))
+		}
+	}
+
+	prog, err = NewProg(code+"ö", Opts{AllowUnicode: true, WrapLongLines: true})
+	if err != nil {
+		t.Fatalf("err is not is nil: %s", err)
+	}
+	if prog.Code() != code+"\nö" {
+		t.Fatal("code is not equal")
+	}
+}
+
+func Test_NewProg_UnsupportedOpts(t *testing.T) {
+	for _, opts := range []Opts{
+		{MaxSteps: -1},
+		{MaxStackDepth: -1},
+		{MaxCodeCells: -1},
+		{MaxCodeCells: Width*Height - 1},
+	} {
+		_, err := NewProg("@", opts)
+
+		if !errors.Is(err, ErrUnsupportedOpts) {
+			t.Fatalf("should fail")
+		}
+
+		var cerr *CompilationError
+		if !errors.As(err, &cerr) {
+			t.Fatalf("should be a CompilationError")
+		}
+	}
+}
+
+func Test_Prog_Cell(t *testing.T) {
+	prog, err := NewProg("ab\ncd", Opts{})
+	if err != nil {
+		t.Fatalf("err is not is nil: %s", err)
+	}
+
+	w, h := prog.Size()
+	if w != Width || h != Height {
+		t.Fatal("invalid size")
+	}
+
+	if prog.Cell(0, 0) != 'a' || prog.Cell(1, 1) != 'd' || prog.Cell(2, 1) != ' ' {
+		t.Fatal("invalid cell")
+	}
+	if prog.Cell(-1, 0) != 0 || prog.Cell(0, Height) != 0 {
+		t.Fatal("invalid cell")
+	}
+}
@@ -0,0 +1,110 @@
+package bef93
+
+import (
+	"errors"
+	"math/rand"
+)
+
+// ErrInvalidRand is returned by Exec() if a RandSource returns a value out of range.
+// Will be wrapped in a RuntimeError, so use errors.Is/As().
+var ErrInvalidRand = errors.New("random source returned a value out of range")
+
+// RandSource is a source of random numbers, used by '?' and,
+// if Opts.ReadErrorUndefined is set, for undefined input values.
+// *rand.Rand from math/rand implements it.
+// If the source also has an Int63() int64 method, it is used for undefined input values.
+// See WithRandSource().
+type RandSource interface {
+	// Intn returns a uniformly distributed random number in [0, n), n > 0.
+	Intn(n int) int
+}
+
+// RandCloner can be implemented by a RandSource to support Proc.Clone().
+type RandCloner interface {
+	// CloneRand returns an independent copy of the source,
+	// which continues with the same sequence of numbers.
+	CloneRand() RandSource
+}
+
+// WithRandSource makes the proc use src instead of the default
+// math/rand generator seeded with Opts.RandSeed.
+func WithRandSource(src RandSource) ProcOption {
+	return func(p *Proc) {
+		p.rand = src
+	}
+}
+
+// countingSource counts the values drawn from a rand.Source.
+type countingSource struct {
+	src   rand.Source
+	draws uint64
+}
+
+func (s *countingSource) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *countingSource) Seed(seed int64) {
+	s.src.Seed(seed)
+	s.draws = 0
+}
+
+// seededRand is the default RandSource.
+// The generator state is fully described by the seed and the number of values
+// drawn, which allows to copy it by replaying the sequence.
+type seededRand struct {
+	*rand.Rand
+	seed int64
+	src  *countingSource
+}
+
+// compile time interface check
+var _ RandCloner = &seededRand{}
+
+// newSeededRand creates a generator with the given seed, with draws values already drawn.
+// Takes time proportional to draws.
+func newSeededRand(seed int64, draws uint64) *seededRand {
+	src := &countingSource{src: rand.NewSource(seed)}
+	for src.draws < draws {
+		src.Int63()
+	}
+
+	return &seededRand{
+		// #nosec G404 We want to be deterministic here.
+		Rand: rand.New(src),
+		seed: seed,
+		src:  src,
+	}
+}
+
+// CloneRand implements RandCloner.
+// Replays all values drawn so far, so the cost grows with the number of values drawn.
+func (r *seededRand) CloneRand() RandSource {
+	return newSeededRand(r.seed, r.src.draws)
+}
+
+// cloneRand returns the source to use for a clone of a proc.
+// Sources which do not implement RandCloner are shared.
+func cloneRand(src RandSource) RandSource {
+	if c, ok := src.(RandCloner); ok {
+		return c.CloneRand()
+	}
+	return src
+}
+
+// randInt63 returns a non-negative random number with 63 bits from src.
+// Uses the Int63() method if src has one (*rand.Rand does), otherwise
+// combines several values from Intn(), which is limited to 31 bits on
+// 32-bit platforms.
+func randInt63(src RandSource) int64 {
+	if r, ok := src.(interface{ Int63() int64 }); ok {
+		return r.Int63()
+	}
+
+	val := uint64(0)
+	for i := 0; i < 4; i++ {
+		val = val<<16 | uint64(src.Intn(1<<16))
+	}
+	return int64(val >> 1)
+}
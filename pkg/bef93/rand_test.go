@@ -0,0 +1,136 @@
+package bef93
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pushes the digits passed by the PC in the direction chosen by '?'
+const randCode = `>v
+3?2
+ 4`
+
+func Test_Rand_Clone(t *testing.T) {
+	prog, err := NewProg(randCode, Opts{RandSeed: 42, MaxSteps: 2000})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	proc := NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{})
+	_, err = proc.StepN(1000)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	clone := proc.Clone(&bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{})
+	for _, p := range []*Proc{proc, clone} {
+		err = p.Exec()
+		if !errors.Is(err, ErrStepLimitExceeded) {
+			t.Fatalf("expected ErrStepLimitExceeded, got %v", err)
+		}
+	}
+
+	if len(proc.State().Stack) < 20 {
+		t.Fatal("expected some random choices")
+	}
+	if !reflect.DeepEqual(proc.State(), clone.State()) {
+		t.Fatal("should be equal")
+	}
+}
+
+// fixedRand always returns the same value.
+type fixedRand int
+
+func (r fixedRand) Intn(int) int { return int(r) }
+
+func Test_WithRandSource(t *testing.T) {
+	prog, err := NewProg(randCode, Opts{MaxSteps: 200})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	proc := NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}, WithRandSource(fixedRand(DirDown)))
+	err = proc.Exec()
+	if !errors.Is(err, ErrStepLimitExceeded) {
+		t.Fatalf("expected ErrStepLimitExceeded, got %v", err)
+	}
+
+	stack := proc.State().Stack
+	if len(stack) < 4 {
+		t.Fatal("expected some random choices")
+	}
+	for _, v := range stack {
+		if v != 4 {
+			t.Fatalf("invalid stack %v", stack)
+		}
+	}
+}
+
+func Test_Rand_CloneDraws(t *testing.T) {
+	r := newSeededRand(42, 1000)
+	want := r.Int63()
+
+	clone := newSeededRand(42, 1000)
+	if clone.Int63() != want {
+		t.Fatal("should be equal")
+	}
+	if r.CloneRand().(*seededRand).Int63() != r.Int63() {
+		t.Fatal("should be equal")
+	}
+}
+
+func Test_Rand_Seeds(t *testing.T) {
+	// '?' must keep producing the same directions for a given seed
+	tests := []struct {
+		seed int64
+		dirs []Direction
+	}{
+		{1, []Direction{1, 3, 3, 3, 1, 2, 1, 0}},
+		{2, []Direction{2, 2, 0, 0, 0, 2, 2, 0}},
+		{3, []Direction{0, 1, 0, 2, 1, 3, 0, 1}},
+		{42, []Direction{1, 3, 0, 2, 3, 1, 1, 0}},
+	}
+
+	code := strings.TrimSuffix(strings.Repeat(strings.Repeat("?", Width)+"\n", Height), "\n")
+	for _, tt := range tests {
+		prog, err := NewProg(code, Opts{RandSeed: tt.seed})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		proc := NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{})
+		for _, dir := range tt.dirs {
+			if _, err := proc.Step(); err != nil {
+				t.Fatalf(err.Error())
+			}
+			if proc.State().Dir != dir {
+				t.Fatalf("seed %d: expected %v, got %v", tt.seed, dir, proc.State().Dir)
+			}
+		}
+	}
+}
+
+func Test_Rand_Int63(t *testing.T) {
+	if randInt63(fixedRand(1<<16-1)) != math.MaxInt64 {
+		t.Fatal("should be equal")
+	}
+	if randInt63(newSeededRand(1, 0)) < 0 {
+		t.Fatal("should be non-negative")
+	}
+}
+
+func Test_WithRandSource_Invalid(t *testing.T) {
+	prog, err := NewProg(randCode, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	proc := NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}, WithRandSource(fixedRand(dirEND)))
+	err = proc.Exec()
+	if !errors.Is(err, ErrInvalidRand) || errors.Is(err, ErrReplayDiverged) {
+		t.Fatalf("expected ErrInvalidRand, got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package bef93
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrReplayDiverged is returned by Exec() if a proc created using WithReplay()
+// requests a nondeterministic event which was not recorded.
+// Will be wrapped in a RuntimeError, so use errors.Is/As().
+var ErrReplayDiverged = errors.New("replay diverged")
+
+// EventKind is the kind of a nondeterministic event.
+type EventKind uint8
+
+const (
+	// EventRand is a random direction chosen by '?'.
+	EventRand EventKind = iota
+	// EventInput is a value pushed by an input operation, i.e. '&', '~',
+	// or the answer to a division by zero.
+	EventInput
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventRand:
+		return "rand"
+	case EventInput:
+		return "input"
+	default:
+		return fmt.Sprintf("EventKind(%d)", k)
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (k EventKind) MarshalText() ([]byte, error) {
+	if k != EventRand && k != EventInput {
+		return nil, fmt.Errorf("invalid event kind %d", k)
+	}
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (k *EventKind) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "rand":
+		*k = EventRand
+	case "input":
+		*k = EventInput
+	default:
+		return fmt.Errorf("invalid event kind '%s'", b)
+	}
+	return nil
+}
+
+// Event is a nondeterministic event which happened during execution.
+type Event struct {
+	Kind EventKind `json:"kind"`
+	// Val is the chosen Direction for EventRand, and the pushed value for EventInput.
+	Val int64 `json:"val"`
+}
+
+// ReplayLog holds the nondeterministic events of a run, in order.
+// It can be serialized to JSON.
+type ReplayLog struct {
+	Events []Event `json:"events"`
+}
+
+// WithRecording records all nondeterministic events consumed by the proc to log.
+// Events undone by StepBack() are removed from the log again.
+func WithRecording(log *ReplayLog) ProcOption {
+	return func(p *Proc) {
+		p.recording = log
+	}
+}
+
+// WithReplay makes the proc consume the nondeterministic events from log,
+// instead of reading input or choosing random directions.
+// Given the same program and options, the recorded run is reproduced exactly.
+// If the program requests more or different events than recorded,
+// ErrReplayDiverged is returned.
+// The log is not modified.
+func WithReplay(log *ReplayLog) ProcOption {
+	return func(p *Proc) {
+		p.replay = &replayer{events: log.Events}
+	}
+}
+
+// replayer consumes the events of a replay log.
+type replayer struct {
+	events []Event
+	pos    int
+}
+
+func (r *replayer) next(kind EventKind) (int64, error) {
+	if r.pos >= len(r.events) {
+		return 0, fmt.Errorf("%w: %s event requested after all %d events were consumed", ErrReplayDiverged, kind, len(r.events))
+	}
+
+	ev := r.events[r.pos]
+	if ev.Kind != kind {
+		return 0, fmt.Errorf("%w: %s event requested, but event %d is %s", ErrReplayDiverged, kind, r.pos, ev.Kind)
+	}
+
+	r.pos++
+	return ev.Val, nil
+}
+
+// nextEvent returns the next event to replay, if any.
+func (p *Proc) nextEvent(kind EventKind) (int64, bool, error) {
+	if len(p.redo) > 0 {
+		ev := p.redo[len(p.redo)-1]
+		if ev.Kind == kind {
+			p.redo = p.redo[:len(p.redo)-1]
+			return ev.Val, true, nil
+		}
+
+		// should not happen, as the grid is restored too
+		p.redo = nil
+	}
+
+	if p.replay != nil {
+		val, err := p.replay.next(kind)
+		if err != nil {
+			return 0, false, p.newRuntimeError(err)
+		}
+		return val, true, nil
+	}
+
+	return 0, false, nil
+}
+
+// recordEvent records an event consumed by the current step.
+func (p *Proc) recordEvent(kind EventKind, val int64) {
+	if p.recording != nil {
+		p.recording.Events = append(p.recording.Events, Event{Kind: kind, Val: val})
+	}
+
+	if p.journal != nil {
+		e := p.journal.last()
+		e.hasEvent = true
+		e.event = Event{Kind: kind, Val: val}
+	}
+}
+
+// randDir returns a random direction for the '?' operation.
+func (p *Proc) randDir() (Direction, error) {
+	val, ok, err := p.nextEvent(EventRand)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		if val < 0 || val >= int64(dirEND) {
+			return 0, p.newRuntimeError(fmt.Errorf("%w: invalid direction %d", ErrReplayDiverged, val))
+		}
+	} else {
+		val = int64(p.rand.Intn(int(dirEND)))
+		if val < 0 || val >= int64(dirEND) {
+			return 0, p.newRuntimeError(fmt.Errorf("%w: Intn(%d) returned %d", ErrInvalidRand, dirEND, val))
+		}
+	}
+
+	p.recordEvent(EventRand, val)
+	return Direction(val), nil
+}
+
+// input returns the value of an input operation, read using read.
+func (p *Proc) input(read func() (int64, error)) (int64, error) {
+	val, ok, err := p.nextEvent(EventInput)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		val, err = read()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	p.recordEvent(EventInput, val)
+	return val, nil
+}
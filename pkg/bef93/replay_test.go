@@ -0,0 +1,139 @@
+package bef93
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// prints the input, then a random number, '?' going up loops
+const replayCode = `&.~,v
+@.1 ?2.@
+    3
+    .
+    @`
+
+func Test_Replay(t *testing.T) {
+	for seed := int64(1); seed <= 10; seed++ {
+		prog, err := NewProg(replayCode, Opts{RandSeed: seed})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		log := &ReplayLog{}
+		stdout := &bytes.Buffer{}
+		err = NewProc(prog, strings.NewReader("42\nx"), stdout, &bytes.Buffer{}, WithRecording(log)).Exec()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if len(log.Events) < 3 || log.Events[0] != (Event{EventInput, 42}) || log.Events[1] != (Event{EventInput, 'x'}) {
+			t.Fatalf("invalid log %+v", log.Events)
+		}
+
+		// replay with a different seed and without input
+		prog, err = NewProg(replayCode, Opts{RandSeed: seed + 100})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		replayed := &bytes.Buffer{}
+		err = NewProc(prog, strings.NewReader(""), replayed, &bytes.Buffer{}, WithReplay(log)).Exec()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if replayed.String() != stdout.String() {
+			t.Fatalf("should be equal: %q %q", replayed.String(), stdout.String())
+		}
+	}
+}
+
+func Test_Replay_Diverged(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event
+	}{
+		{"exhausted", []Event{{EventInput, 42}}},
+		{"different kind", []Event{{EventInput, 42}, {EventRand, 0}}},
+		{"invalid direction", []Event{{EventInput, 42}, {EventInput, 'x'}, {EventRand, 4}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := NewProg(replayCode, Opts{})
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			log := &ReplayLog{Events: tt.events}
+			err = NewProc(prog, strings.NewReader("42\nx"), &bytes.Buffer{}, &bytes.Buffer{}, WithReplay(log)).Exec()
+			if !errors.Is(err, ErrReplayDiverged) {
+				t.Fatalf("expected ErrReplayDiverged, got %v", err)
+			}
+			var rerr *RuntimeError
+			if !errors.As(err, &rerr) {
+				t.Fatal("expected a RuntimeError")
+			}
+		})
+	}
+}
+
+func Test_Replay_JSON(t *testing.T) {
+	log := &ReplayLog{Events: []Event{{EventInput, 42}, {EventRand, int64(DirDown)}}}
+
+	b, err := json.Marshal(log)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(b) != `{"events":[{"kind":"input","val":42},{"kind":"rand","val":1}]}` {
+		t.Fatalf("should be equal: %s", b)
+	}
+
+	parsed := &ReplayLog{}
+	err = json.Unmarshal(b, parsed)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !reflect.DeepEqual(parsed, log) {
+		t.Fatal("should be equal")
+	}
+
+	err = json.Unmarshal([]byte(`{"events":[{"kind":"foo","val":42}]}`), parsed)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func Test_Replay_RecordingStepBack(t *testing.T) {
+	prog, err := NewProg(`&&@`, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	log := &ReplayLog{}
+	proc := NewProc(prog, strings.NewReader("1\n2\n"), &bytes.Buffer{}, &bytes.Buffer{}, WithRecording(log), WithJournal(10))
+	_, err = proc.StepN(2)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	err = proc.StepBack()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !reflect.DeepEqual(log.Events, []Event{{EventInput, 1}}) {
+		t.Fatalf("invalid log %+v", log.Events)
+	}
+
+	err = proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !reflect.DeepEqual(log.Events, []Event{{EventInput, 1}, {EventInput, 2}}) {
+		t.Fatalf("invalid log %+v", log.Events)
+	}
+}
@@ -0,0 +1,325 @@
+package bef93
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// ErrInvalidSnapshot is returned when unmarshaling an invalid snapshot.
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// snapshotVersion is incremented on incompatible changes of the snapshot format.
+const snapshotVersion = 1
+
+// maxSnapshotDraws limits the random values drawn in a snapshot,
+// because restoring replays them.
+const maxSnapshotDraws = 1 << 28
+
+// cellSnapshot is a cell which can not be represented in a UTF-8 string.
+type cellSnapshot struct {
+	X   int  `json:"x"`
+	Y   int  `json:"y"`
+	Val rune `json:"val"`
+}
+
+type progSnapshot struct {
+	Version int            `json:"version"`
+	Code    []string       `json:"code"`
+	Cells   []cellSnapshot `json:"cells,omitempty"`
+	Opts    Opts           `json:"opts"`
+}
+
+type randSnapshot struct {
+	Seed  int64  `json:"seed"`
+	Draws uint64 `json:"draws"`
+}
+
+type procSnapshot struct {
+	Version int           `json:"version"`
+	Prog    progSnapshot  `json:"prog"`
+	PCX     int           `json:"pc_x"`
+	PCY     int           `json:"pc_y"`
+	Dir     Direction     `json:"dir"`
+	StrMode bool          `json:"str_mode"`
+	Stack   []int64       `json:"stack"`
+	Steps   int64         `json:"steps"`
+	Done    bool          `json:"done"`
+	Rand    *randSnapshot `json:"rand,omitempty"`
+	Redo    []Event       `json:"redo,omitempty"`
+}
+
+func invalidSnapshot(format string, a ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidSnapshot, fmt.Sprintf(format, a...))
+}
+
+func encodeGob(v any) ([]byte, error) {
+	b := bytes.Buffer{}
+	err := gob.NewEncoder(&b).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decodeGob(data []byte, v any) error {
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSnapshot, err)
+	}
+	return nil
+}
+
+func decodeJSON(data []byte, v any) error {
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSnapshot, err)
+	}
+	return nil
+}
+
+func (p *Prog) snapshot() progSnapshot {
+	s := progSnapshot{
+		Version: snapshotVersion,
+		Code:    make([]string, p.h),
+		Opts:    p.opts,
+	}
+
+	for y, l := range p.code {
+		for x, r := range l {
+			if !utf8.ValidRune(r) {
+				s.Cells = append(s.Cells, cellSnapshot{X: x, Y: y, Val: r})
+			}
+		}
+		s.Code[y] = string(l)
+	}
+
+	return s
+}
+
+// restore validates s and replaces the contents of p.
+func (p *Prog) restore(s progSnapshot) error {
+	if s.Version != snapshotVersion {
+		return invalidSnapshot("unsupported version %d", s.Version)
+	}
+	if err := validateOpts(s.Opts); err != nil {
+		return invalidSnapshot("%s", err)
+	}
+
+	h := len(s.Code)
+	if h == 0 {
+		return invalidSnapshot("empty code")
+	}
+
+	// validate the size before allocating the grid
+	w := utf8.RuneCountInString(s.Code[0])
+	if s.Opts.AllowArbitraryCodeSize && (w < Width || h < Height) ||
+		!s.Opts.AllowArbitraryCodeSize && (w != Width || h != Height) {
+		return invalidSnapshot("invalid size %dx%d", w, h)
+	}
+	if s.Opts.MaxCodeCells > 0 && w > s.Opts.MaxCodeCells/h {
+		return invalidSnapshot("size %dx%d exceeds limit of %d cells", w, h, s.Opts.MaxCodeCells)
+	}
+
+	code := make([][]rune, h)
+	for y, l := range s.Code {
+		if utf8.RuneCountInString(l) != w {
+			return invalidSnapshot("rows of different width")
+		}
+		code[y] = []rune(l)
+	}
+
+	for _, c := range s.Cells {
+		if c.X < 0 || c.X >= w || c.Y < 0 || c.Y >= h {
+			return invalidSnapshot("cell (%d, %d) out of bounds", c.X, c.Y)
+		}
+		code[c.Y][c.X] = c.Val
+	}
+
+	if !s.Opts.AllowUnicode {
+		for y, l := range code {
+			for x, r := range l {
+				if r < 0 || r > 0xff {
+					return invalidSnapshot("non ASCII cell at (%d, %d)", x, y)
+				}
+			}
+		}
+	}
+
+	p.code = code
+	p.w, p.h = w, h
+	p.opts = s.Opts
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+// The snapshot holds the code, including modifications by 'p', and the options.
+func (p *Prog) MarshalBinary() ([]byte, error) {
+	return encodeGob(p.snapshot())
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// Errors wrap ErrInvalidSnapshot.
+func (p *Prog) UnmarshalBinary(data []byte) error {
+	s := progSnapshot{}
+	if err := decodeGob(data, &s); err != nil {
+		return err
+	}
+	return p.restore(s)
+}
+
+// MarshalJSON implements json.Marshaler, see MarshalBinary().
+func (p *Prog) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.snapshot())
+}
+
+// UnmarshalJSON implements json.Unmarshaler, see UnmarshalBinary().
+func (p *Prog) UnmarshalJSON(data []byte) error {
+	s := progSnapshot{}
+	if err := decodeJSON(data, &s); err != nil {
+		return err
+	}
+	return p.restore(s)
+}
+
+func (p *Proc) snapshot() procSnapshot {
+	s := procSnapshot{
+		Version: snapshotVersion,
+		Prog:    p.prog.snapshot(),
+		PCX:     p.pcX,
+		PCY:     p.pcY,
+		Dir:     p.dir,
+		StrMode: p.strMode,
+		Stack:   p.stack.values(),
+		Steps:   p.steps,
+		Done:    p.done,
+		Redo:    p.redo,
+	}
+
+	if r, ok := p.rand.(*seededRand); ok {
+		s.Rand = &randSnapshot{Seed: r.seed, Draws: r.src.draws}
+	}
+
+	return s
+}
+
+// restore validates s and replaces the state of p.
+func (p *Proc) restore(s procSnapshot) error {
+	if s.Version != snapshotVersion {
+		return invalidSnapshot("unsupported version %d", s.Version)
+	}
+
+	prog := Prog{}
+	if err := prog.restore(s.Prog); err != nil {
+		return err
+	}
+
+	if s.PCX < 0 || s.PCX >= prog.w || s.PCY < 0 || s.PCY >= prog.h {
+		return invalidSnapshot("PC (%d, %d) out of bounds", s.PCX, s.PCY)
+	}
+	if s.Dir >= dirEND {
+		return invalidSnapshot("invalid direction %d", s.Dir)
+	}
+	if s.Steps < 0 {
+		return invalidSnapshot("negative step count")
+	}
+	if s.Rand != nil && s.Rand.Draws > maxSnapshotDraws {
+		return invalidSnapshot("%d random values drawn, limit is %d", s.Rand.Draws, maxSnapshotDraws)
+	}
+
+	p.prog.code, p.prog.w, p.prog.h, p.prog.opts = prog.code, prog.w, prog.h, prog.opts
+	if p.compiled != nil {
+		p.compiled = compileProg(&p.prog)
+	}
+	p.pcX, p.pcY = s.PCX, s.PCY
+	p.dir = s.Dir
+	p.strMode = s.StrMode
+	p.steps = s.Steps
+	p.done = s.Done
+	p.redo = append([]Event(nil), s.Redo...)
+
+	p.stack.sp = 0
+	for _, v := range s.Stack {
+		p.stack.push(v)
+	}
+
+	if _, ok := p.rand.(*seededRand); ok {
+		switch {
+		case s.Rand != nil:
+			p.rand = newSeededRand(s.Rand.Seed, s.Rand.Draws)
+		case prog.opts.RandSeed != 0:
+			p.rand = newSeededRand(prog.opts.RandSeed, 0)
+		}
+	}
+
+	if p.journal != nil {
+		p.journal.start, p.journal.len = 0, 0
+	}
+	p.resumeSteps = 0
+	p.watchHit = nil
+
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+// The snapshot holds the program (see Prog.MarshalBinary()), the PC, direction,
+// string mode, stack, step count and the state of the default random source.
+// I/O streams, buffered input, options, breakpoints and the journal are not included.
+// Must not be called while the proc is executing.
+func (p *Proc) MarshalBinary() ([]byte, error) {
+	return encodeGob(p.snapshot())
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// Replaces the program and state of p, keeping its I/O streams, options and
+// breakpoints, and clearing its journal.
+// If p uses a random source given by WithRandSource(), it is kept.
+// Errors wrap ErrInvalidSnapshot.
+// Use RestoreProc() to create a new proc from a snapshot.
+func (p *Proc) UnmarshalBinary(data []byte) error {
+	s := procSnapshot{}
+	if err := decodeGob(data, &s); err != nil {
+		return err
+	}
+	return p.restore(s)
+}
+
+// MarshalJSON implements json.Marshaler, see MarshalBinary().
+func (p *Proc) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.snapshot())
+}
+
+// UnmarshalJSON implements json.Unmarshaler, see UnmarshalBinary().
+func (p *Proc) UnmarshalJSON(data []byte) error {
+	s := procSnapshot{}
+	if err := decodeJSON(data, &s); err != nil {
+		return err
+	}
+	return p.restore(s)
+}
+
+// RestoreProc creates a new Proc from a snapshot created by Proc.MarshalBinary()
+// or Proc.MarshalJSON(). Like with Proc.Clone(), you need to supply new I/O pipes
+// and options.
+func RestoreProc(data []byte, in io.Reader, out, outErr io.Writer, opts ...ProcOption) (*Proc, error) {
+	p := NewProc(&Prog{}, in, out, outErr)
+
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		err = p.UnmarshalJSON(data)
+	} else {
+		err = p.UnmarshalBinary(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p, nil
+}
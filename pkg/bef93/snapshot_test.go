@@ -0,0 +1,221 @@
+package bef93
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Proc_Snapshot(t *testing.T) {
+	// randCode, but overwrites the '4' with a '5' first
+	code := `"5"13pv
+>v     <
+3?2
+ 4`
+
+	for _, format := range []string{"binary", "json"} {
+		t.Run(format, func(t *testing.T) {
+			prog, err := NewProg(code, Opts{RandSeed: 7, MaxSteps: 3000})
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			proc := NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{})
+			_, err = proc.StepN(1000)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			var data []byte
+			if format == "binary" {
+				data, err = proc.MarshalBinary()
+			} else {
+				data, err = json.Marshal(proc)
+			}
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			restored, err := RestoreProc(data, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{})
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if !reflect.DeepEqual(restored.State(), proc.State()) || restored.Prog().Code() != proc.Prog().Code() {
+				t.Fatal("should be equal")
+			}
+			if restored.Prog().Cell(1, 3) != '5' {
+				t.Fatal("should be equal")
+			}
+
+			for _, p := range []*Proc{proc, restored} {
+				err = p.Exec()
+				if !errors.Is(err, ErrStepLimitExceeded) {
+					t.Fatalf("expected ErrStepLimitExceeded, got %v", err)
+				}
+			}
+			if !reflect.DeepEqual(restored.State(), proc.State()) {
+				t.Fatal("should be equal")
+			}
+		})
+	}
+}
+
+func Test_Proc_UnmarshalBinary(t *testing.T) {
+	prog, err := NewProg(`&.@`, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	proc := NewProc(prog, strings.NewReader("1\n"), &bytes.Buffer{}, &bytes.Buffer{})
+	_, err = proc.Step()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	data, err := proc.MarshalBinary()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// restore into another proc, keeping its I/O streams
+	out := &bytes.Buffer{}
+	other := NewProc(prog, strings.NewReader("2\n"), out, &bytes.Buffer{})
+	err = other.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	err = other.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if out.String() != "1 " || other.Steps() != 3 {
+		t.Fatal("should be equal")
+	}
+}
+
+func Test_Proc_Snapshot_RandDraws(t *testing.T) {
+	prog, err := NewProg(randCode, Opts{RandSeed: 7})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	proc := NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{})
+	data, err := json.Marshal(proc)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// restoring replays the values drawn, so their number is limited
+	data = bytes.Replace(data, []byte(`"draws":0`), []byte(`"draws":18446744073709551615`), 1)
+	_, err = RestoreProc(data, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{})
+	if !errors.Is(err, ErrInvalidSnapshot) {
+		t.Fatalf("expected ErrInvalidSnapshot, got %v", err)
+	}
+}
+
+func Test_Prog_Snapshot(t *testing.T) {
+	prog, err := NewProg(`05-00p@`, Opts{AllowUnicode: true})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// write an invalid rune to (0, 0)
+	proc := NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{})
+	err = proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	prog = proc.Prog()
+
+	data, err := json.Marshal(prog)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !bytes.Contains(data, []byte(`"cells":[{"x":0,"y":0,"val":-5}]`)) {
+		t.Fatalf("should contain the invalid cell: %s", data)
+	}
+
+	restored := &Prog{}
+	err = json.Unmarshal(data, restored)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if restored.Cell(0, 0) != -5 || restored.Code() != prog.Code() || restored.Opts() != prog.Opts() {
+		t.Fatal("should be equal")
+	}
+
+	data, err = prog.MarshalBinary()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	restored = &Prog{}
+	err = restored.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if restored.Cell(0, 0) != -5 || restored.Code() != prog.Code() {
+		t.Fatal("should be equal")
+	}
+}
+
+func Test_Snapshot_Invalid(t *testing.T) {
+	prog, err := NewProg(`@`, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	data, err := json.Marshal(NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"garbage", "foo"},
+		{"invalid json", "{"},
+		{"version", strings.Replace(string(data), `"version":1`, `"version":2`, 1)},
+		{"pc", strings.Replace(string(data), `"pc_x":0`, `"pc_x":80`, 1)},
+		{"direction", strings.Replace(string(data), `"dir":0`, `"dir":4`, 1)},
+		{"size", strings.Replace(string(data), `"code":["@`, `"code":["@ `, 1)},
+		{"ascii", strings.Replace(string(data), `"code":["@`, `"code":["€`, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.data == string(data) {
+				t.Fatal("test data not modified")
+			}
+
+			_, err := RestoreProc([]byte(tt.data), &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{})
+			if !errors.Is(err, ErrInvalidSnapshot) {
+				t.Fatalf("expected ErrInvalidSnapshot, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_Snapshot_TooLarge(t *testing.T) {
+	prog, err := NewProg(`@`, Opts{AllowArbitraryCodeSize: true, MaxCodeCells: Width * Height})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	s := NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}).snapshot()
+	// one more row than allowed, with a first row claiming a huge width
+	s.Prog.Code = append(s.Prog.Code, s.Prog.Code[0])
+	s.Prog.Code[0] = strings.Repeat(" ", 1<<20)
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	_, err = RestoreProc(data, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{})
+	if !errors.Is(err, ErrInvalidSnapshot) {
+		t.Fatalf("expected ErrInvalidSnapshot, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "exceeds limit") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
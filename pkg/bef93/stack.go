@@ -50,3 +50,11 @@ func (s *stack) clone() stack {
 		sp: s.sp,
 	}
 }
+
+// values returns a copy of the values on the stack, bottom first.
+func (s *stack) values() []int64 {
+	ret := make([]int64, s.sp)
+	copy(ret, s.s)
+
+	return ret
+}
@@ -0,0 +1,127 @@
+package bef93
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// TraceStackValues is the number of stack values recorded per step by a Tracer.
+const TraceStackValues = 8
+
+// TraceRecord is a single executed instruction, as written by a Tracer.
+type TraceRecord struct {
+	// Step is the number of the step, starting at 1.
+	Step int64 `json:"step"`
+	// X and Y are the location of the executed instruction.
+	X int `json:"x"`
+	Y int `json:"y"`
+	// Op is the executed instruction, or the pushed character in string mode.
+	Op string `json:"op"`
+	// Dir and StrMode are the direction and string mode before the instruction was executed.
+	Dir     string `json:"dir"`
+	StrMode bool   `json:"str_mode,omitempty"`
+	// Stack holds the top TraceStackValues stack values after the instruction
+	// was executed, top first.
+	Stack []int64 `json:"stack"`
+	// StackLen is the number of values on the stack after the instruction was executed.
+	StackLen int `json:"stack_len"`
+	// Output is the output written by the instruction, if any.
+	Output string `json:"output,omitempty"`
+	// Input is the value read by the instruction, if any.
+	Input *int64 `json:"input,omitempty"`
+	// Put is the grid write by a 'p' instruction, if any.
+	Put *TracePut `json:"put,omitempty"`
+	// Error is the error the instruction failed with, if any.
+	// Stack and StackLen are the state after the failure.
+	Error string `json:"error,omitempty"`
+}
+
+// TracePut is a grid write recorded in a TraceRecord.
+type TracePut struct {
+	X   int    `json:"x"`
+	Y   int    `json:"y"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// Tracer is an Observer writing a TraceRecord per executed instruction
+// as a line of JSON (JSON Lines format).
+// Traces of two runs can be diffed line by line to find where they diverge.
+// Instructions which fail with an error are recorded with the error.
+type Tracer struct {
+	enc *json.Encoder
+	rec TraceRecord
+	err error
+}
+
+// compile time interface checks
+var (
+	_ Observer     = &Tracer{}
+	_ FailObserver = &Tracer{}
+)
+
+// NewTracer creates a Tracer writing to w.
+// Attach it to a Proc using WithObserver().
+// w should be buffered, as every record is written using a separate Write() call.
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{enc: json.NewEncoder(w)}
+}
+
+// Err returns the first error encountered while writing the trace.
+// After an error, no more records are written.
+func (t *Tracer) Err() error {
+	return t.err
+}
+
+// BeforeStep implements Observer.
+func (t *Tracer) BeforeStep(state ProcState) {
+	t.rec = TraceRecord{
+		Step:    state.Steps + 1,
+		X:       state.PCX,
+		Y:       state.PCY,
+		Dir:     state.Dir.String(),
+		StrMode: state.StrMode,
+	}
+}
+
+// AfterStep implements Observer.
+func (t *Tracer) AfterStep(state ProcState, op rune) {
+	t.write(state, op)
+}
+
+// OnFail implements FailObserver.
+func (t *Tracer) OnFail(state ProcState, op rune, err error) {
+	t.rec.Error = err.Error()
+	t.write(state, op)
+}
+
+// write completes and writes the record of the current step.
+func (t *Tracer) write(state ProcState, op rune) {
+	if t.err != nil {
+		return
+	}
+
+	t.rec.Op = string(op)
+	t.rec.StackLen = len(state.Stack)
+	t.rec.Stack = make([]int64, 0, TraceStackValues)
+	for i := len(state.Stack) - 1; i >= 0 && len(t.rec.Stack) < TraceStackValues; i-- {
+		t.rec.Stack = append(t.rec.Stack, state.Stack[i])
+	}
+
+	t.err = t.enc.Encode(&t.rec)
+}
+
+// OnPut implements Observer.
+func (t *Tracer) OnPut(x, y int, old, val rune) {
+	t.rec.Put = &TracePut{X: x, Y: y, Old: string(old), New: string(val)}
+}
+
+// OnOutput implements Observer.
+func (t *Tracer) OnOutput(b []byte) {
+	t.rec.Output += string(b)
+}
+
+// OnInput implements Observer.
+func (t *Tracer) OnInput(val int64) {
+	t.rec.Input = &val
+}
@@ -0,0 +1,82 @@
+package bef93
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_Tracer(t *testing.T) {
+	prog, err := NewProg(`~"x"10p.@`, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	trace := &bytes.Buffer{}
+	tracer := NewTracer(trace)
+	proc := NewProc(prog, strings.NewReader("a"), &bytes.Buffer{}, &bytes.Buffer{}, WithObserver(tracer))
+	err = proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if tracer.Err() != nil {
+		t.Fatalf(tracer.Err().Error())
+	}
+
+	expected := `{"step":1,"x":0,"y":0,"op":"~","dir":"right","stack":[97],"stack_len":1,"input":97}
+{"step":2,"x":1,"y":0,"op":"\"","dir":"right","stack":[97],"stack_len":1}
+{"step":3,"x":2,"y":0,"op":"x","dir":"right","str_mode":true,"stack":[120,97],"stack_len":2}
+{"step":4,"x":3,"y":0,"op":"\"","dir":"right","str_mode":true,"stack":[120,97],"stack_len":2}
+{"step":5,"x":4,"y":0,"op":"1","dir":"right","stack":[1,120,97],"stack_len":3}
+{"step":6,"x":5,"y":0,"op":"0","dir":"right","stack":[0,1,120,97],"stack_len":4}
+{"step":7,"x":6,"y":0,"op":"p","dir":"right","stack":[97],"stack_len":1,"put":{"x":1,"y":0,"old":"\"","new":"x"}}
+{"step":8,"x":7,"y":0,"op":".","dir":"right","stack":[],"stack_len":0,"output":"97 "}
+{"step":9,"x":8,"y":0,"op":"@","dir":"right","stack":[],"stack_len":0}
+`
+
+	if trace.String() != expected {
+		t.Fatalf("should be equal:\n%s", trace.String())
+	}
+}
+
+func Test_Tracer_StackValues(t *testing.T) {
+	prog, err := NewProg(`0123456789@`, Opts{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	trace := &bytes.Buffer{}
+	proc := NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}, WithObserver(NewTracer(trace)))
+	err = proc.Exec()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	lines := strings.Split(strings.TrimSpace(trace.String()), "\n")
+	if lines[len(lines)-1] != `{"step":11,"x":10,"y":0,"op":"@","dir":"right","stack":[9,8,7,6,5,4,3,2],"stack_len":10}` {
+		t.Fatalf("should be equal: %s", lines[len(lines)-1])
+	}
+}
+
+func Test_Tracer_Fail(t *testing.T) {
+	prog, err := NewProg(`10/@`, Opts{DisallowDivZero: true})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	trace := &bytes.Buffer{}
+	proc := NewProc(prog, &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}, WithObserver(NewTracer(trace)))
+	err = proc.Exec()
+	if !errors.Is(err, ErrDivZero) {
+		t.Fatalf("expected ErrDivZero, got %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(trace.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(lines))
+	}
+	if lines[2] != `{"step":3,"x":2,"y":0,"op":"/","dir":"right","stack":[],"stack_len":0,"error":"`+err.Error()+`"}` {
+		t.Fatalf("should be equal: %s", lines[2])
+	}
+}